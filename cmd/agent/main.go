@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"sync"
@@ -15,6 +18,7 @@ import (
 	"golang.org/x/exp/slog"
 
 	"mrvacommander/pkg/agent"
+	"mrvacommander/pkg/metrics"
 	"mrvacommander/pkg/queue"
 )
 
@@ -66,6 +70,7 @@ func startAndMonitorWorkers(ctx context.Context, queue queue.Queue, desiredWorke
 			wg.Add(1)
 			go agent.RunWorker(ctx, stopChan, queue, wg)
 		}
+		metrics.Workers.Set(float64(desiredWorkerCount))
 		return
 	}
 
@@ -103,18 +108,46 @@ func startAndMonitorWorkers(ctx context.Context, queue queue.Queue, desiredWorke
 				stopChans = stopChans[:newWorkerCount]
 			}
 			currentWorkerCount = newWorkerCount
+			metrics.Workers.Set(float64(currentWorkerCount))
 
 			time.Sleep(monitorIntervalSec * time.Second)
 		}
 	}
 }
 
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
 func main() {
 	slog.Info("Starting agent")
 
 	workerCount := flag.Int("workers", 0, "number of workers")
+	statusAddr := flag.String("status-addr", envOr("MRVA_AGENT_STATUS_ADDR", ":8081"),
+		"address of the /healthz and /status listener, empty to disable")
+	cacheDir := flag.String("db-cache-dir", envOr("MRVA_AGENT_DB_CACHE_DIR", filepath.Join(os.TempDir(), "mrva-db-cache")),
+		"directory of the cache of extracted databases")
+	cacheBytes := flag.String("db-cache-bytes", envOr("MRVA_AGENT_DB_CACHE_BYTES", "21474836480"),
+		"size budget of the database cache in bytes, 0 to disable the cache")
 	flag.Parse()
 
+	maxCacheBytes, err := strconv.ParseInt(*cacheBytes, 10, 64)
+	if err != nil || maxCacheBytes < 0 {
+		slog.Error("Invalid database cache size", "value", *cacheBytes)
+		os.Exit(1)
+	}
+	if maxCacheBytes > 0 {
+		cache, err := agent.NewDBCache(*cacheDir, maxCacheBytes)
+		if err != nil {
+			slog.Error("Failed to open database cache", slog.Any("error", err))
+			os.Exit(1)
+		}
+		agent.SetDBCache(cache)
+	}
+
 	requiredEnvVars := []string{
 		"MRVA_RABBITMQ_HOST",
 		"MRVA_RABBITMQ_PORT",
@@ -156,6 +189,21 @@ func main() {
 
 	go startAndMonitorWorkers(ctx, rabbitMQQueue, *workerCount, &wg)
 
+	var statusServer *http.Server
+	if *statusAddr != "" {
+		statusServer = &http.Server{
+			Addr:              *statusAddr,
+			Handler:           agent.StatusHandler(rabbitMQQueue),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			slog.Info("Starting status listener", "address", *statusAddr)
+			if err := statusServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Status listener failed", "error", err)
+			}
+		}()
+	}
+
 	slog.Info("Agent started")
 
 	// Gracefully exit on SIGINT/SIGTERM
@@ -164,6 +212,12 @@ func main() {
 	<-sigChan
 	slog.Info("Shutting down agent")
 
+	if statusServer != nil {
+		sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+		statusServer.Shutdown(sctx)
+		scancel()
+	}
+
 	// TODO: fix this to gracefully terminate agent workers during jobs
 	cancel()
 	wg.Wait()
@@ -0,0 +1,123 @@
+// Copyright © 2024 github
+// Licensed under the Apache License, Version 2.0 (the "License").
+
+// Command compare reports the findings added and removed between two
+// variant analysis sessions, e.g. before and after a change to a query.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mrvacommander/pkg/results"
+)
+
+func main() {
+	server := flag.String("server", envOr("MRVA_SERVER", "http://localhost:8080"), "Base URL of the mrvacommander server")
+	token := flag.String("token", os.Getenv("MRVA_TOKEN"), "Bearer token of the actor owning the sessions (default $MRVA_TOKEN)")
+	controller := flag.String("controller", "", "Controller repository as owner/repo")
+	base := flag.Int("base", 0, "Session id of the base run")
+	head := flag.Int("head", 0, "Session id of the head run")
+	details := flag.Bool("details", true, "List the added and removed findings")
+	asJSON := flag.Bool("json", false, "Print the comparison as JSON")
+	failOnDiff := flag.Bool("fail-on-diff", false, "Exit with status 1 if any finding was added or removed")
+
+	flag.Usage = func() {
+		log.Printf("Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		log.Println("\nExamples:")
+		log.Println("  compare -controller hohn/mirva-controller -base 54674 -head 54675 -fail-on-diff")
+	}
+	flag.Parse()
+
+	if *controller == "" || strings.Count(*controller, "/") != 1 || *base == 0 || *head == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/code-scanning/codeql/variant-analyses/%d/compare/%d?details=%t",
+		strings.TrimSuffix(*server, "/"), *controller, *base, *head, *details)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Comparison request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Message != "" {
+			log.Fatalf("Comparison request failed: %s: %s", resp.Status, apiErr.Message)
+		}
+		log.Fatalf("Comparison request failed: %s", resp.Status)
+	}
+
+	var cmp results.Comparison
+	if err := json.NewDecoder(resp.Body).Decode(&cmp); err != nil {
+		log.Fatalf("Invalid comparison response: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cmp); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		printComparison(cmp)
+	}
+
+	if *failOnDiff && (cmp.Added > 0 || cmp.Removed > 0) {
+		os.Exit(1)
+	}
+}
+
+func printComparison(cmp results.Comparison) {
+	fmt.Printf("Comparing session %d (base) with session %d (head)\n\n", cmp.BaseSessionID, cmp.HeadSessionID)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tSTATUS\tADDED\tREMOVED\tUNCHANGED")
+	for _, rc := range cmp.Repositories {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n",
+			rc.Repository, rc.Status, rc.AddedCount, rc.RemovedCount, rc.UnchangedCount)
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t%d\t%d\t%d\n", cmp.Added, cmp.Removed, cmp.Unchanged)
+	tw.Flush()
+
+	for _, rc := range cmp.Repositories {
+		for _, f := range rc.Added {
+			fmt.Printf("+ %s %s\n", rc.Repository, describe(f))
+		}
+		for _, f := range rc.Removed {
+			fmt.Printf("- %s %s\n", rc.Repository, describe(f))
+		}
+	}
+}
+
+func describe(f results.Finding) string {
+	if f.Tuple != nil {
+		return fmt.Sprintf("%s: %s", f.RuleID, strings.Join(f.Tuple, ", "))
+	}
+	return fmt.Sprintf("%s %s:%d: %s", f.RuleID, f.Location.Path, f.Location.StartLine, f.Message)
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/qldbimport"
+	"mrvacommander/pkg/qldbstore"
+)
+
+// remoteStore registers databases through the server's upload API.
+type remoteStore struct {
+	c *client
+}
+
+func (r remoteStore) Register(nwo common.NameWithOwner, fname, language string) (qldbstore.DBInfo, error) {
+	info, err := uploadDB(r.c, nwo.Owner+"/"+nwo.Repo, fname, language)
+	var ae *apiError
+	if errors.As(err, &ae) &&
+		(ae.status == http.StatusUnprocessableEntity || ae.status == http.StatusRequestEntityTooLarge) {
+		return info, fmt.Errorf("%w: %v", qldbstore.ErrInvalidDatabase, err)
+	}
+	return info, err
+}
+
+// Known asks the server for databases with the checksum.
+func (r remoteStore) Known(sum string) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, r.c.url("/databases?per_page=1&checksum="+url.QueryEscape(sum)), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := r.c.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, responseError(resp)
+	}
+	var list struct {
+		TotalCount int `json:"total_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return false, err
+	}
+	return list.TotalCount > 0, nil
+}
+
+func importDBs(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var c client
+	c.flags(fs)
+	storeDir := fs.String("store", "codeql/dbs", "Database store to import into, unless -remote is set")
+	remote := fs.Bool("remote", false, "Upload to -server instead of writing to -store")
+	dir := fs.String("dir", "", "Import the databases in this tree, laid out as {owner}/{repo}/*.zip")
+	manifest := fs.String("manifest", "", "Import the databases listed in this JSONL file of owner, repo, language and path or url")
+	jobs := fs.Int("j", 4, "Number of databases imported concurrently")
+	statePath := fs.String("state", "qldb-import.state", "File recording finished databases, to resume an interrupted import")
+	reportPath := fs.String("report", "", "Write a JSON report to this file")
+	fs.Parse(args)
+
+	if (*dir == "") == (*manifest == "") || *jobs < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	var items []qldbimport.Item
+	var err error
+	if *dir != "" {
+		items, err = qldbimport.WalkDir(*dir)
+	} else {
+		items, err = qldbimport.ReadManifest(*manifest)
+	}
+	if err != nil {
+		log.Fatalf("Unable to list databases: %v", err)
+	}
+
+	var reg qldbimport.Registrar
+	if *remote {
+		reg = remoteStore{c: &c}
+	} else {
+		store, err := qldbstore.NewStoreAt(*storeDir)
+		if err != nil {
+			log.Fatalf("Unable to open database store %s: %v", *storeDir, err)
+		}
+		reg = qldbimport.LocalStore{Store: store}
+	}
+
+	imp, err := qldbimport.NewImporter(reg, *statePath)
+	if err != nil {
+		log.Fatalf("Unable to read import state: %v", err)
+	}
+	defer imp.Close()
+
+	report := imp.Run(items, *jobs)
+
+	log.Printf("%d imported, %d duplicate, %d already done, %d invalid, %d failed",
+		report.Imported, report.Duplicate, report.Resumed, len(report.Invalid), len(report.Failed))
+	for _, rec := range report.Invalid {
+		log.Printf("invalid: %s (%s/%s): %s", rec.Source, rec.Owner, rec.Repo, rec.Error)
+	}
+	for _, rec := range report.Failed {
+		log.Printf("failed: %s (%s/%s): %s", rec.Source, rec.Owner, rec.Repo, rec.Error)
+	}
+	if *reportPath != "" {
+		if err := qldbimport.WriteReport(*reportPath, report); err != nil {
+			log.Fatalf("Unable to write report: %v", err)
+		}
+	}
+	if len(report.Invalid) > 0 || len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+}
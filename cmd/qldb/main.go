@@ -0,0 +1,221 @@
+// Copyright © 2024 github
+// Licensed under the Apache License, Version 2.0 (the "License").
+
+// Command qldb manages the CodeQL databases of a mrvacommander server.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"mrvacommander/pkg/qldbstore"
+)
+
+func usage() {
+	log.Printf("Usage of %s:\n", os.Args[0])
+	log.Println("  qldb upload [flags] owner/repo database.zip")
+	log.Println("  qldb import [flags] -dir directory | -manifest databases.jsonl")
+	log.Println("  qldb export [flags]")
+	log.Println("\nRun a command with -help for its flags.")
+	log.Println("\nExamples:")
+	log.Println("  qldb upload -language cpp google/flatbuffers google_flatbuffers_db.zip")
+	log.Println("  qldb import -store codeql/dbs -dir /data/dbs -j 8 -report report.json")
+	log.Println("  qldb export -language cpp -cli-version 2.17 -o databases.json")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "upload":
+		upload(os.Args[2:])
+	case "import":
+		importDBs(os.Args[2:])
+	case "export":
+		export(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// client holds the connection flags shared by the commands.
+type client struct {
+	server string
+	token  string
+}
+
+func (c *client) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.server, "server", envOr("MRVA_SERVER", "http://localhost:8080"), "Base URL of the mrvacommander server")
+	fs.StringVar(&c.token, "token", os.Getenv("MRVA_TOKEN"), "Bearer token of an admin (default $MRVA_TOKEN)")
+}
+
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (c *client) url(p string) string {
+	return strings.TrimSuffix(c.server, "/") + p
+}
+
+func upload(args []string) {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	var c client
+	c.flags(fs)
+	language := fs.String("language", "", "Reject the database unless it is for this language")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 || strings.Count(fs.Arg(0), "/") != 1 {
+		usage()
+		os.Exit(2)
+	}
+	info, err := uploadDB(&c, fs.Arg(0), fs.Arg(1), *language)
+	if err != nil {
+		log.Fatalf("Upload of %s failed: %v", fs.Arg(1), err)
+	}
+	fmt.Printf("%s/%s: %s database of commit %s stored as %s (%d bytes)\n",
+		info.Owner, info.Repo, info.Language, info.SHA, info.Path, info.Size)
+}
+
+// uploadDB sends the database archive at fname to the server as a database
+// of nwo.
+func uploadDB(c *client, nwo, fname, language string) (qldbstore.DBInfo, error) {
+	var info qldbstore.DBInfo
+	f, err := os.Open(fname)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return info, err
+	}
+
+	u := c.url("/admin/databases/" + nwo)
+	if language != "" {
+		u += "?language=" + url.QueryEscape(language)
+	}
+	req, err := http.NewRequest(http.MethodPost, u, f)
+	if err != nil {
+		return info, err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/zip")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return info, responseError(resp)
+	}
+	return info, json.NewDecoder(resp.Body).Decode(&info)
+}
+
+// export writes the repositories with matching databases to a repository
+// list file.
+func export(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var c client
+	c.flags(fs)
+	out := fs.String("o", "databases.json", "Repository list file to write, - for standard output")
+	params := map[string]*string{}
+	for _, p := range []struct{ flag, param, help string }{
+		{"name", "name", "Name of the repository list"},
+		{"owner", "owner", "Only repositories of this owner"},
+		{"language", "language", "Only databases for this language"},
+		{"cli-version", "cli_version", "Only databases created by this CodeQL CLI version or release series"},
+		{"created-after", "created_after", "Only databases created after this time or date"},
+		{"created-before", "created_before", "Only databases created before this time or date"},
+		{"min-size", "min_size", "Only databases of at least this many bytes"},
+		{"max-size", "max_size", "Only databases of at most this many bytes"},
+	} {
+		params[p.param] = fs.String(p.flag, "", p.help)
+	}
+	fs.Parse(args)
+
+	q := url.Values{}
+	for name, val := range params {
+		if *val != "" {
+			q.Set(name, *val)
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, c.url("/databases/export?"+q.Encode()), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Export failed: %v", responseError(resp))
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+}
+
+// apiError is a failed request to the server.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+// responseError describes a failed request using the API error body, if any.
+func responseError(resp *http.Response) error {
+	var apiErr struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	buf, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(buf, &apiErr) != nil || apiErr.Message == "" {
+		return &apiError{status: resp.StatusCode, msg: resp.Status}
+	}
+	msg := apiErr.Message
+	for _, e := range apiErr.Errors {
+		if e.Message != "" {
+			msg += ": " + e.Message
+		}
+	}
+	return &apiError{status: resp.StatusCode, msg: resp.Status + ": " + msg}
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
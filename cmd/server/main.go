@@ -4,14 +4,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"mrvacommander/config/mcc"
 
 	"mrvacommander/pkg/agent"
+	"mrvacommander/pkg/auth"
 	"mrvacommander/pkg/logger"
 	"mrvacommander/pkg/qldbstore"
 	"mrvacommander/pkg/qpstore"
@@ -92,13 +96,20 @@ func main() {
 			os.Exit(1)
 		}
 
-		server.NewCommanderSingle(&server.Visibles{
+		au, err := newAuthenticator(config.Commander)
+		if err != nil {
+			slog.Error("Unable to initialize authentication", "error", err)
+			os.Exit(1)
+		}
+
+		cs := server.NewCommanderSingle(&server.Visibles{
 			Logger:         sl,
 			Queue:          sq,
 			ServerStore:    ss,
 			QueryPackStore: qp,
 			QLDBStore:      ql,
-		})
+			Auth:           au,
+		}, config.Commander)
 
 		// FIXME take value from configuration
 		agent.NewAgentSingle(2, &agent.Visibles{
@@ -108,6 +119,8 @@ func main() {
 			QLDBStore:      ql,
 		})
 
+		serve(cs)
+
 	case "container":
 		// Assemble container version
 		sl := logger.NewLoggerSingle(&logger.Visibles{})
@@ -131,6 +144,12 @@ func main() {
 			os.Exit(1)
 		}
 
+		au, err := newAuthenticator(config.Commander)
+		if err != nil {
+			slog.Error("Unable to initialize authentication", "error", err)
+			os.Exit(1)
+		}
+
 		agent.NewAgentSingle(2, &agent.Visibles{
 			Logger:         sl,
 			Queue:          sq,
@@ -138,13 +157,16 @@ func main() {
 			QLDBStore:      ql,
 		})
 
-		server.NewCommanderSingle(&server.Visibles{
+		cs := server.NewCommanderSingle(&server.Visibles{
 			Logger:         sl,
 			Queue:          sq,
 			ServerStore:    ss,
 			QueryPackStore: qp,
 			QLDBStore:      ql,
-		})
+			Auth:           au,
+		}, config.Commander)
+
+		serve(cs)
 
 	case "cluster":
 		// Assemble cluster version
@@ -154,3 +176,28 @@ func main() {
 	}
 
 }
+
+// serve runs the API until SIGTERM or interrupt.
+func serve(cs *server.CommanderSingle) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if err := cs.Serve(ctx); err != nil {
+		slog.Error("Server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func newAuthenticator(cfg mcc.Commander) (auth.Authenticator, error) {
+	jwtSecret := cfg.JWTSecret
+	if jwtSecret == "" {
+		jwtSecret = os.Getenv("MRVA_JWT_SECRET")
+	}
+	au, err := auth.New(cfg.TokensFile, jwtSecret, cfg.JWTIssuer)
+	if err != nil {
+		return nil, err
+	}
+	if au == nil {
+		slog.Warn("No tokens file or JWT secret configured, the API is unauthenticated")
+	}
+	return au, nil
+}
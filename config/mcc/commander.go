@@ -1,4 +1,115 @@
 package mcc
 
+import "time"
+
 type Commander struct {
+	HTTP HTTP
+
+	// Secret for signing artifact download tokens.  When empty, the
+	// MRVA_ARTIFACT_SECRET environment variable is used, and failing that a
+	// random secret, which invalidates issued download links on restart.
+	ArtifactSecret string
+	// How long an artifact download link stays valid, e.g. "1h"
+	ArtifactTTL time.Duration
+
+	// TOML file of static bearer tokens, see auth.LoadTokenFile
+	TokensFile string
+	// Secret for HS256 JWT bearer tokens.  When empty, the MRVA_JWT_SECRET
+	// environment variable is used.  Without tokens file or JWT secret the
+	// API is unauthenticated.
+	JWTSecret string
+	// Required issuer of JWT bearer tokens, if set
+	JWTIssuer string
+
+	// TOML file of rules limiting which repositories' databases an actor
+	// may analyze, see auth.LoadPolicyFile.  Unset allows everything.
+	PolicyFile string
+
+	// Controller repositories accepting submissions; more can be added
+	// through the admin API
+	ControllerRepos []ControllerRepo
+
+	Quotas Quotas
+	// How long an analysis may stay queued or running, "6h" when zero.
+	// Older analyses fail, so they can be retried, and no longer count as
+	// running against the session quotas.
+	JobTimeout time.Duration
+
+	// Largest database archive accepted for upload, in bytes; 4 GiB when
+	// zero.  Large uploads also need a generous HTTP.ReadTimeout.
+	MaxDatabaseBytes int64
+
+	Retention Retention
+
+	// Endpoints notified when sessions complete or repositories fail
+	Webhooks []Webhook
+	// Delivery attempts per webhook event, 5 when zero
+	WebhookMaxAttempts int
+}
+
+// Expiry of completed sessions with their query packs and result archives.
+// Sessions still in progress are never expired.
+type Retention struct {
+	// Age after which sessions are deleted, e.g. "720h"; zero keeps them
+	MaxAge time.Duration
+	// Disk budget in bytes for query packs and result archives; the oldest
+	// sessions are deleted first when it is exceeded.  Zero means no limit.
+	MaxTotalBytes int64
+	// How often to sweep, "1h" when zero
+	SweepInterval time.Duration
+}
+
+type Webhook struct {
+	URL string
+	// Key of the HMAC-SHA256 signature in X-MRVA-Signature-256
+	Secret string
+	// session.completed, repository.failed; all when empty
+	Events []string
+}
+
+// HTTP listener of the server
+type HTTP struct {
+	// Address to listen on, ":8080" when empty
+	ListenAddress string
+	// URL under which clients reach the server, used in the links it hands
+	// out.  Derived from ListenAddress when empty, which is only right when
+	// clients run on the same host.
+	PublicURL string
+	// PEM certificate and key; TLS is enabled when both are set
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Timeouts, e.g. "30s".  Zero means no timeout except for
+	// ReadHeaderTimeout, IdleTimeout and ShutdownTimeout, which have
+	// defaults.  WriteTimeout bounds whole downloads, so keep it generous.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// How long to wait for in-flight requests on shutdown
+	ShutdownTimeout time.Duration
+}
+
+// Submission limits per actor and for the whole server.  Zero means
+// unlimited.
+type Quotas struct {
+	// Repositories analyzed per session; the rest are skipped as over limit
+	MaxReposPerSession int
+	// Sessions with analyses still queued or running, within JobTimeout
+	MaxConcurrentSessions int
+	// Repositories analyzed per UTC day
+	DailyAnalysisBudget int
+
+	// Sessions still running, of all actors together
+	GlobalMaxConcurrentSessions int
+	// Repositories analyzed per UTC day, by all actors together
+	GlobalDailyAnalysisBudget int
+}
+
+type ControllerRepo struct {
+	// Stable numeric id; derived from owner and name when zero
+	ID         int
+	Owner      string
+	Name       string
+	Visibility string
 }
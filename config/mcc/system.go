@@ -17,7 +17,7 @@ type System struct {
 
 func LoadConfig(fname string) *System {
 	if _, err := os.Stat(fname); err != nil {
-		slog.Error("Configuration file %s not found", fname)
+		slog.Error("Configuration file not found", "file", fname)
 		os.Exit(1)
 	}
 
@@ -25,7 +25,7 @@ func LoadConfig(fname string) *System {
 
 	_, err := toml.DecodeFile(fname, &config)
 	if err != nil {
-		slog.Error("", err)
+		slog.Error("Unable to parse configuration file", "file", fname, "error", err)
 		os.Exit(1)
 	}
 
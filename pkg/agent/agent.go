@@ -77,11 +77,23 @@ func (r *RunnerSingle) worker(wid int) {
 func RunAnalysisJob(job common.AnalyzeJob) (common.AnalyzeResult, error) {
 	var result = common.AnalyzeResult{
 		RequestId:        job.RequestId,
+		QueryPackId:      job.QueryPackId,
+		NWO:              job.NWO,
 		ResultCount:      0,
 		ResultArchiveURL: "",
 		Status:           common.StatusError,
 	}
 
+	cliVersion, err := codeql.GetCLIVersion()
+	if err != nil {
+		slog.Warn("Unable to determine CodeQL CLI version, result will not be cached", "error", err)
+	}
+	result.CacheKey = common.CacheKey{
+		QueryPackHash:    job.QueryPackHash,
+		DatabaseChecksum: job.DatabaseChecksum,
+		CLIVersion:       cliVersion,
+	}
+
 	// Create a temporary directory
 	tempDir := filepath.Join(os.TempDir(), uuid.New().String())
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
@@ -96,7 +108,21 @@ func RunAnalysisJob(job common.AnalyzeJob) (common.AnalyzeResult, error) {
 	utils.UntarGz("qp-54674.tgz", queryPackPath)
 
 	// Perform the CodeQL analysis
-	runResult, err := codeql.RunQuery("google_flatbuffers_db.zip", "cpp", queryPackPath, tempDir)
+	database := job.DatabasePath
+	if database == "" {
+		database = "google_flatbuffers_db.zip" // FIXME jobs queued by older servers
+	}
+	var runResult *codeql.RunQueryResult
+	if cache := getDBCache(); cache != nil {
+		db, aerr := cache.Acquire(database, job.DatabaseChecksum)
+		if aerr != nil {
+			return result, fmt.Errorf("failed to prepare database: %w", aerr)
+		}
+		defer db.Release()
+		runResult, err = codeql.RunQueryOnDatabase(db.Path, "cpp", queryPackPath, tempDir)
+	} else {
+		runResult, err = codeql.RunQuery(database, "cpp", queryPackPath, tempDir)
+	}
 	if err != nil {
 		return result, fmt.Errorf("failed to run analysis: %w", err)
 	}
@@ -107,15 +133,11 @@ func RunAnalysisJob(job common.AnalyzeJob) (common.AnalyzeResult, error) {
 		return result, fmt.Errorf("failed to generate results archive: %w", err)
 	}
 
-	// TODO: Upload the archive to storage
 	slog.Debug("Results archive size", slog.Int("size", len(resultsArchive)))
 
-	result = common.AnalyzeResult{
-		RequestId:        job.RequestId,
-		ResultCount:      runResult.ResultCount,
-		ResultArchiveURL: "REPLACE_THIS_WITH_STORED_RESULTS_ARCHIVE", // TODO
-		Status:           common.StatusSuccess,
-	}
+	result.ResultCount = runResult.ResultCount
+	result.ResultArchive = resultsArchive
+	result.Status = common.StatusSuccess
 
 	return result, nil
 }
@@ -128,7 +150,9 @@ func RunWorker(ctx context.Context, stopChan chan struct{}, queue queue.Queue, w
 	)
 
 	defer wg.Done()
-	slog.Info("Worker started")
+	wid := workerStarted()
+	defer workerStopped(wid)
+	slog.Info("Worker started", "worker", wid)
 	for {
 		select {
 		case <-stopChan:
@@ -144,12 +168,20 @@ func RunWorker(ctx context.Context, stopChan chan struct{}, queue queue.Queue, w
 					return
 				}
 				slog.Info("Running analysis job", slog.Any("job", job))
+				jobStarted(wid, job)
 				result, err := RunAnalysisJob(job)
+				jobFinished(wid, err)
 				if err != nil {
-					slog.Error("Failed to run analysis job", slog.Any("error", err))
-					continue
+					// Failures are reported too, so the session completes
+					// and the repository can be retried
+					slog.Error("Failed to run analysis job", "session", job.QueryPackId,
+						"owner/repo", job.NWO, "error", err)
+					result.Status = common.StatusError
+				} else {
+					slog.Info("Analysis job completed", "session", result.QueryPackId,
+						"owner/repo", result.NWO, "result_count", result.ResultCount,
+						"archive_size", len(result.ResultArchive))
 				}
-				slog.Info("Analysis job completed", slog.Any("result", result))
 				queue.Results() <- result
 			case <-stopChan:
 				slog.Info(WORKER_COUNT_STOP_MESSAGE)
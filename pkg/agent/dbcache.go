@@ -0,0 +1,431 @@
+package agent
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mrvacommander/pkg/metrics"
+	"mrvacommander/utils"
+)
+
+// DBCache keeps extracted databases on the agent so that analyses of the
+// same database in later sessions skip the unzip.  Entries are keyed by the
+// sha256 of the database archive, live in {dir}/{checksum} and are evicted
+// least recently used first once they exceed the size budget.  Entries in
+// use are never evicted.
+type DBCache struct {
+	dir      string
+	maxBytes int64
+
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+	lru     *list.List // of *cacheEntry, most recently used first
+	size    int64
+
+	hits, misses, evictions int
+}
+
+type cacheEntry struct {
+	checksum string
+	root     string // database root, the directory of codeql-database.yml
+	size     int64
+	refs     int
+	elem     *list.Element // nil once removed from the cache
+
+	ready chan struct{} // closed when extraction finished
+	err   error
+
+	// CodeQL writes results and evaluation caches into the database, so
+	// analyses of one database take turns
+	inUse sync.Mutex
+}
+
+// CachedDB is a database acquired from a DBCache, to be released when the
+// analysis is done.
+type CachedDB struct {
+	// Database root to pass to CodeQL
+	Path string
+
+	cache *DBCache
+	entry *cacheEntry
+	once  sync.Once
+}
+
+// DBCacheStats is the state of a DBCache reported by the agent status.
+type DBCacheStats struct {
+	Dir       string `json:"dir"`
+	MaxBytes  int64  `json:"max_bytes"`
+	Bytes     int64  `json:"bytes"`
+	Entries   int    `json:"entries"`
+	InUse     int    `json:"in_use"`
+	Hits      int    `json:"hits"`
+	Misses    int    `json:"misses"`
+	Evictions int    `json:"evictions"`
+}
+
+// cacheManifest marks a completed extraction; directories without one are
+// partial and discarded.
+const cacheManifest = ".mrva-cache.json"
+
+type cacheManifestFile struct {
+	Checksum    string    `json:"checksum"`
+	Size        int64     `json:"size"`
+	Root        string    `json:"root"` // relative to the entry directory
+	ExtractedAt time.Time `json:"extracted_at"`
+}
+
+var checksumPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// NewDBCache opens the cache in dir, keeping the complete entries of an
+// earlier run, and limits it to maxBytes of extracted databases.  Files in
+// dir that are not cache entries are left alone.
+func NewDBCache(dir string, maxBytes int64) (*DBCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := DBCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*cacheEntry),
+		lru:      list.New(),
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	found := []cacheManifestFile{}
+	for _, de := range des {
+		name := de.Name()
+		switch {
+		case strings.HasPrefix(name, ".tmp-"):
+		case checksumPattern.MatchString(name):
+			md, err := readCacheManifest(filepath.Join(dir, name))
+			if err == nil && md.Checksum == name {
+				found = append(found, md)
+				continue
+			}
+		default:
+			slog.Warn("Ignoring unknown file in database cache", "path", filepath.Join(dir, name))
+			continue
+		}
+		slog.Info("Removing incomplete database cache entry", "dir", filepath.Join(dir, name))
+		os.RemoveAll(filepath.Join(dir, name))
+	}
+	// Oldest at the back of the LRU list
+	sort.Slice(found, func(i, j int) bool { return found[i].ExtractedAt.After(found[j].ExtractedAt) })
+	for _, md := range found {
+		e := &cacheEntry{
+			checksum: md.Checksum,
+			root:     filepath.Join(dir, md.Checksum, md.Root),
+			size:     md.Size,
+			ready:    make(chan struct{}),
+		}
+		close(e.ready)
+		e.elem = c.lru.PushBack(e)
+		c.entries[e.checksum] = e
+		c.size += e.size
+	}
+
+	c.mutex.Lock()
+	trash := c.evictLocked()
+	c.mutex.Unlock()
+	removeAll(trash)
+
+	slog.Info("Opened database cache", "dir", dir, "entries", len(c.entries), "bytes", c.size, "max_bytes", maxBytes)
+	return &c, nil
+}
+
+func readCacheManifest(entryDir string) (cacheManifestFile, error) {
+	var md cacheManifestFile
+	buf, err := os.ReadFile(filepath.Join(entryDir, cacheManifest))
+	if err != nil {
+		return md, err
+	}
+	if err := json.Unmarshal(buf, &md); err != nil {
+		return md, err
+	}
+	if _, err := os.Stat(filepath.Join(entryDir, md.Root, "codeql-database.yml")); err != nil {
+		return md, err
+	}
+	return md, nil
+}
+
+// Acquire returns the extracted database of the archive with the given
+// sha256, extracting it first if it is not cached.  An empty or malformed
+// checksum is computed from the archive.  The caller has the database to
+// itself until it calls Release.
+func (c *DBCache) Acquire(archive, checksum string) (*CachedDB, error) {
+	sum := ""
+	if !checksumPattern.MatchString(checksum) {
+		var err error
+		if sum, err = utils.FileChecksum(archive); err != nil {
+			return nil, err
+		}
+		checksum = sum
+	}
+	return c.acquire(archive, checksum, sum)
+}
+
+// acquire is Acquire with sum, the checksum of the archive if it is already
+// computed, so that an extraction need not read the archive twice.
+func (c *DBCache) acquire(archive, checksum, sum string) (*CachedDB, error) {
+	c.mutex.Lock()
+	e, hit := c.entries[checksum]
+	if hit {
+		c.hits++
+		c.lru.MoveToFront(e.elem)
+	} else {
+		c.misses++
+		e = &cacheEntry{checksum: checksum, ready: make(chan struct{})}
+		e.elem = c.lru.PushFront(e)
+		c.entries[checksum] = e
+	}
+	e.refs++
+	c.mutex.Unlock()
+
+	if hit {
+		metrics.DBCacheLookups.WithLabelValues("hit").Inc()
+	} else {
+		metrics.DBCacheLookups.WithLabelValues("miss").Inc()
+		c.fill(e, archive, sum)
+	}
+	<-e.ready
+	if e.err != nil {
+		c.release(e)
+		return nil, e.err
+	}
+
+	e.inUse.Lock()
+	// The entry may have been damaged since it was extracted
+	if _, err := os.Stat(filepath.Join(e.root, "codeql-database.yml")); err != nil {
+		e.inUse.Unlock()
+		slog.Warn("Cached database is damaged, extracting it again", "checksum", checksum, "error", err)
+		c.mutex.Lock()
+		trash := c.removeLocked(e)
+		c.mutex.Unlock()
+		removeAll([]string{trash})
+		c.release(e)
+		return c.acquire(archive, checksum, sum)
+	}
+	// Results of earlier analyses must not be mistaken for this one's
+	if err := os.RemoveAll(filepath.Join(e.root, "results")); err != nil {
+		e.inUse.Unlock()
+		c.release(e)
+		return nil, err
+	}
+	return &CachedDB{Path: e.root, cache: c, entry: e}, nil
+}
+
+// Release returns the database to the cache.
+func (db *CachedDB) Release() {
+	db.once.Do(func() {
+		db.entry.inUse.Unlock()
+		db.cache.release(db.entry)
+	})
+}
+
+func (c *DBCache) release(e *cacheEntry) {
+	c.mutex.Lock()
+	e.refs--
+	trash := c.evictLocked()
+	c.mutex.Unlock()
+	removeAll(trash)
+}
+
+// fill extracts the archive into the entry after checking that it has the
+// expected checksum, unless sum is that checksum already.
+func (c *DBCache) fill(e *cacheEntry, archive, sum string) {
+	start := time.Now()
+	md, err := c.extract(archive, e.checksum, sum)
+	if err == nil {
+		metrics.ObservePhase(metrics.PhaseUnzip, start)
+		slog.Info("Extracted database into cache", "archive", archive, "checksum", e.checksum,
+			"bytes", md.Size, "seconds", time.Since(start).Seconds())
+	}
+
+	c.mutex.Lock()
+	if err != nil {
+		e.err = err
+		if e.elem != nil {
+			c.lru.Remove(e.elem)
+			e.elem = nil
+			delete(c.entries, e.checksum)
+		}
+	} else {
+		e.root = filepath.Join(c.dir, e.checksum, md.Root)
+		e.size = md.Size
+		c.size += md.Size
+	}
+	trash := c.evictLocked()
+	c.mutex.Unlock()
+	removeAll(trash)
+	close(e.ready)
+}
+
+func (c *DBCache) extract(archive, checksum, sum string) (cacheManifestFile, error) {
+	md := cacheManifestFile{Checksum: checksum}
+
+	var err error
+	if sum == "" {
+		if sum, err = utils.FileChecksum(archive); err != nil {
+			return md, err
+		}
+	}
+	if sum != checksum {
+		return md, fmt.Errorf("database %s has checksum %s, expected %s", archive, sum, checksum)
+	}
+
+	tmp, err := os.MkdirTemp(c.dir, ".tmp-")
+	if err != nil {
+		return md, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := utils.UnzipFile(archive, tmp); err != nil {
+		return md, fmt.Errorf("failed to unzip database: %w", err)
+	}
+	if md.Root, err = databaseRoot(tmp); err != nil {
+		return md, err
+	}
+	if md.Size, err = dirSize(tmp); err != nil {
+		return md, err
+	}
+	md.ExtractedAt = time.Now()
+	buf, err := json.Marshal(md)
+	if err != nil {
+		return md, err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, cacheManifest), buf, 0644); err != nil {
+		return md, err
+	}
+
+	dst := filepath.Join(c.dir, checksum)
+	// Left over from an entry removed while in use
+	if err := os.RemoveAll(dst); err != nil {
+		return md, err
+	}
+	return md, os.Rename(tmp, dst)
+}
+
+// evictLocked removes least recently used entries not in use until the
+// cache fits its budget, returning the directories to delete.
+func (c *DBCache) evictLocked() []string {
+	trash := []string{}
+	for elem := c.lru.Back(); elem != nil && c.size > c.maxBytes; {
+		prev := elem.Prev()
+		e := elem.Value.(*cacheEntry)
+		if e.refs == 0 {
+			c.evictions++
+			trash = append(trash, c.removeLocked(e))
+		}
+		elem = prev
+	}
+	if c.size > c.maxBytes {
+		slog.Warn("Database cache exceeds its budget, all entries are in use", "bytes", c.size, "max_bytes", c.maxBytes)
+	}
+	metrics.DBCacheBytes.Set(float64(c.size))
+	return trash
+}
+
+// removeLocked takes e out of the cache and moves its directory aside so a
+// new entry for the same database can be extracted at once.  It returns the
+// directory to delete.
+func (c *DBCache) removeLocked(e *cacheEntry) string {
+	if e.elem == nil {
+		return ""
+	}
+	c.lru.Remove(e.elem)
+	e.elem = nil
+	delete(c.entries, e.checksum)
+	c.size -= e.size
+
+	dir := filepath.Join(c.dir, e.checksum)
+	trash := filepath.Join(c.dir, fmt.Sprintf(".tmp-evicted-%s-%d", e.checksum[:12], time.Now().UnixNano()))
+	if err := os.Rename(dir, trash); err != nil {
+		slog.Warn("Unable to remove database cache entry", "dir", dir, "error", err)
+		return ""
+	}
+	return trash
+}
+
+func removeAll(dirs []string) {
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("Unable to delete database cache entry", "dir", dir, "error", err)
+		}
+	}
+}
+
+// Stats returns the state of the cache.
+func (c *DBCache) Stats() DBCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	st := DBCacheStats{
+		Dir:       c.dir,
+		MaxBytes:  c.maxBytes,
+		Bytes:     c.size,
+		Entries:   len(c.entries),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	for _, e := range c.entries {
+		if e.refs > 0 {
+			st.InUse++
+		}
+	}
+	return st
+}
+
+// databaseRoot finds the directory of codeql-database.yml in an extracted
+// archive: the top level or the single directory the database was bundled
+// in.  It returns the path relative to dir.
+func databaseRoot(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "codeql-database.yml")); err == nil {
+		return ".", nil
+	}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, de := range des {
+		if de.IsDir() && !strings.HasPrefix(de.Name(), ".") {
+			if _, err := os.Stat(filepath.Join(dir, de.Name(), "codeql-database.yml")); err == nil {
+				return de.Name(), nil
+			}
+		}
+	}
+	return "", errors.New("archive holds no codeql-database.yml")
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
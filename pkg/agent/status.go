@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"mrvacommander/pkg/codeql"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/metrics"
+	"mrvacommander/pkg/queue"
+	"mrvacommander/utils"
+)
+
+// Status is the report of the agent status listener.
+type Status struct {
+	Version          utils.BuildInfo `json:"version"`
+	CodeQLCLIVersion string          `json:"codeql_cli_version,omitempty"`
+	Workers          int             `json:"workers"`
+	CurrentJobs      []CurrentJob    `json:"current_jobs"`
+	JobsCompleted    int             `json:"jobs_completed"`
+	JobsFailed       int             `json:"jobs_failed"`
+	DBCache          *DBCacheStats   `json:"db_cache,omitempty"`
+}
+
+type CurrentJob struct {
+	Worker     int       `json:"worker"`
+	SessionID  int       `json:"session_id"`
+	Repository string    `json:"repository"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+var (
+	statusMutex   sync.Mutex
+	nextWorkerID  int
+	workers       = make(map[int]bool)
+	currentJobs   = make(map[int]CurrentJob)
+	jobsCompleted int
+	jobsFailed    int
+	dbCache       *DBCache
+)
+
+// SetDBCache makes the workers of this process analyze databases from
+// cache, extracting each database once.  Without a cache every job
+// extracts its database afresh.
+func SetDBCache(c *DBCache) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	dbCache = c
+}
+
+func getDBCache() *DBCache {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	return dbCache
+}
+
+func workerStarted() int {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	nextWorkerID++
+	workers[nextWorkerID] = true
+	return nextWorkerID
+}
+
+func workerStopped(wid int) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	delete(workers, wid)
+	delete(currentJobs, wid)
+}
+
+func jobStarted(wid int, job common.AnalyzeJob) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	metrics.BusyWorkers.Inc()
+	currentJobs[wid] = CurrentJob{
+		Worker:     wid,
+		SessionID:  job.QueryPackId,
+		Repository: fmt.Sprintf("%s/%s", job.NWO.Owner, job.NWO.Repo),
+		StartedAt:  time.Now(),
+	}
+}
+
+func jobFinished(wid int, err error) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	metrics.BusyWorkers.Dec()
+	outcome := "succeeded"
+	if err != nil {
+		outcome = "failed"
+		jobsFailed++
+	} else {
+		jobsCompleted++
+	}
+	if j, ok := currentJobs[wid]; ok {
+		metrics.JobDuration.WithLabelValues(outcome).Observe(time.Since(j.StartedAt).Seconds())
+	}
+	delete(currentJobs, wid)
+}
+
+// GetStatus returns a snapshot of the workers of this process.
+func GetStatus() Status {
+	cliVersion, _ := codeql.GetCLIVersion()
+
+	var cacheStats *DBCacheStats
+	if c := getDBCache(); c != nil {
+		st := c.Stats()
+		cacheStats = &st
+	}
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+	st := Status{
+		Version:          utils.GetBuildInfo(),
+		CodeQLCLIVersion: cliVersion,
+		Workers:          len(workers),
+		CurrentJobs:      []CurrentJob{},
+		JobsCompleted:    jobsCompleted,
+		JobsFailed:       jobsFailed,
+		DBCache:          cacheStats,
+	}
+	for _, j := range currentJobs {
+		st.CurrentJobs = append(st.CurrentJobs, j)
+	}
+	sort.Slice(st.CurrentJobs, func(i, j int) bool {
+		return st.CurrentJobs[i].Worker < st.CurrentJobs[j].Worker
+	})
+	return st
+}
+
+// StatusHandler serves /healthz, which fails when the queue connection is
+// lost, /status with the report of GetStatus and /metrics.
+func StatusHandler(q queue.Queue) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if hc, ok := q.(common.HealthChecker); ok {
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			defer cancel()
+			if err := hc.CheckHealth(ctx); err != nil {
+				writeStatusJSON(w, http.StatusServiceUnavailable,
+					map[string]string{"status": "unavailable", "detail": err.Error()})
+				return
+			}
+		}
+		writeStatusJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeStatusJSON(w, http.StatusOK, GetStatus())
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+func writeStatusJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
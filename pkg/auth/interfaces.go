@@ -0,0 +1,6 @@
+package auth
+
+// Authenticator maps a bearer token to the identity it was issued to.
+type Authenticator interface {
+	Authenticate(token string) (Identity, error)
+}
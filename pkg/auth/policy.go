@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"mrvacommander/pkg/common"
+)
+
+// Policy restricts the repositories whose databases an identity may analyze.
+// It is read from a TOML file of the form
+//
+//	[[rule]]
+//	logins = ["alice"]          # "*" for every authenticated user
+//	teams = ["security-lab"]
+//	repos = ["octo-org", "github/codeql", "acme/lib-*"]
+//
+// A rule applies to an identity listed by login or by one of its teams.
+// Repository patterns are matched case-insensitively against owner/repo with
+// path.Match; a bare owner stands for all of its repositories.  Admins may
+// analyze everything, everyone else only what some applicable rule allows.
+type Policy struct {
+	rules []policyRule
+}
+
+type policyRule struct {
+	logins []string
+	teams  []string
+	repos  []string
+}
+
+type policyFile struct {
+	Rule []struct {
+		Logins []string
+		Teams  []string
+		Repos  []string
+	}
+}
+
+// LoadPolicyFile reads an access policy file.
+func LoadPolicyFile(fname string) (*Policy, error) {
+	var pf policyFile
+	if _, err := toml.DecodeFile(fname, &pf); err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %w", fname, err)
+	}
+
+	p := Policy{}
+	for i, r := range pf.Rule {
+		if len(r.Logins) == 0 && len(r.Teams) == 0 {
+			return nil, fmt.Errorf("rule %d in %s applies to no logins or teams", i+1, fname)
+		}
+		pr := policyRule{logins: lower(r.Logins), teams: lower(r.Teams)}
+		for _, pat := range lower(r.Repos) {
+			if !strings.Contains(pat, "/") {
+				pat += "/*"
+			}
+			if _, err := path.Match(pat, ""); err != nil {
+				return nil, fmt.Errorf("rule %d in %s has an invalid repository pattern %q", i+1, fname, pat)
+			}
+			pr.repos = append(pr.repos, pat)
+		}
+		p.rules = append(p.rules, pr)
+	}
+	return &p, nil
+}
+
+// Allows reports whether id may analyze the database of nwo.  A nil policy
+// allows everything.
+func (p *Policy) Allows(id Identity, nwo common.NameWithOwner) bool {
+	if p == nil || id.Admin {
+		return true
+	}
+	full := strings.ToLower(nwo.Owner + "/" + nwo.Repo)
+	for _, r := range p.rules {
+		if !r.appliesTo(id) {
+			continue
+		}
+		for _, pat := range r.repos {
+			if ok, _ := path.Match(pat, full); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r policyRule) appliesTo(id Identity) bool {
+	if id.Login == "" {
+		return false
+	}
+	for _, l := range r.logins {
+		if l == "*" || l == strings.ToLower(id.Login) {
+			return true
+		}
+	}
+	for _, t := range r.teams {
+		for _, it := range id.Teams {
+			if t == strings.ToLower(it) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func lower(ss []string) []string {
+	out := make([]string, 0, len(ss))
+	for _, s := range ss {
+		out = append(out, strings.ToLower(strings.TrimSpace(s)))
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// StaticTokens authenticates against a fixed list of tokens read from a
+// TOML file of the form
+//
+//	[[token]]
+//	sha256 = "9f86d0..."   # hex sha256 of the token, or
+//	token = "plain-text"   # the token itself
+//	login = "alice"
+//	id = 1
+//	admin = false
+//	teams = ["security-lab"]
+type StaticTokens struct {
+	entries []tokenEntry
+}
+
+type tokenEntry struct {
+	hash [sha256.Size]byte
+	id   Identity
+}
+
+type tokenFile struct {
+	Token []struct {
+		Token  string
+		SHA256 string
+		Login  string
+		ID     int
+		Admin  bool
+		Teams  []string
+	}
+}
+
+// LoadTokenFile reads a static tokens file.
+func LoadTokenFile(fname string) (*StaticTokens, error) {
+	var tf tokenFile
+	if _, err := toml.DecodeFile(fname, &tf); err != nil {
+		return nil, fmt.Errorf("failed to read tokens file %s: %w", fname, err)
+	}
+
+	st := StaticTokens{}
+	for i, t := range tf.Token {
+		if t.Login == "" {
+			return nil, fmt.Errorf("token %d in %s has no login", i+1, fname)
+		}
+		var e tokenEntry
+		switch {
+		case t.SHA256 != "":
+			h, err := hex.DecodeString(strings.TrimSpace(t.SHA256))
+			if err != nil || len(h) != sha256.Size {
+				return nil, fmt.Errorf("token %d in %s has an invalid sha256", i+1, fname)
+			}
+			copy(e.hash[:], h)
+		case t.Token != "":
+			e.hash = sha256.Sum256([]byte(t.Token))
+		default:
+			return nil, fmt.Errorf("token %d in %s has neither token nor sha256", i+1, fname)
+		}
+		e.id = Identity{Login: t.Login, ID: t.ID, Admin: t.Admin, Teams: t.Teams}
+		st.entries = append(st.entries, e)
+	}
+	return &st, nil
+}
+
+func (st *StaticTokens) Authenticate(token string) (Identity, error) {
+	h := sha256.Sum256([]byte(token))
+	for _, e := range st.entries {
+		if subtle.ConstantTimeCompare(h[:], e.hash[:]) == 1 {
+			return e.id, nil
+		}
+	}
+	return Identity{}, ErrInvalidToken
+}
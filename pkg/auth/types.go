@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"mrvacommander/pkg/common"
+)
+
+var (
+	ErrNoToken      = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid bearer token")
+	ErrExpiredToken = errors.New("expired bearer token")
+)
+
+// Identity is the authenticated actor behind a request.
+type Identity struct {
+	Login string
+	ID    int
+	Admin bool
+	Teams []string
+}
+
+// Anonymous is the identity of every request when authentication is not
+// configured.  It is an admin so that an unconfigured server behaves as it
+// did before authentication existed.
+var Anonymous = Identity{Admin: true}
+
+type contextKey struct{}
+
+// WithIdentity returns a context carrying id.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity stored by WithIdentity, or Anonymous.
+func FromContext(ctx context.Context) Identity {
+	if id, ok := ctx.Value(contextKey{}).(Identity); ok {
+		return id
+	}
+	return Anonymous
+}
+
+// Chain tries each authenticator in turn and accepts the first identity
+// found.  An empty chain accepts no tokens.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(token string) (Identity, error) {
+	err := ErrInvalidToken
+	for _, a := range c {
+		id, aerr := a.Authenticate(token)
+		if aerr == nil {
+			return id, nil
+		}
+		// Report expiry over a plain mismatch from another authenticator
+		if errors.Is(aerr, ErrExpiredToken) {
+			err = aerr
+		}
+	}
+	return Identity{}, err
+}
+
+// Actor returns the identity in the form used by the GitHub API.
+func (id Identity) Actor() common.Actor {
+	if id.Login == "" {
+		return common.Actor{}
+	}
+	return common.Actor{
+		Login:     id.Login,
+		ID:        id.ID,
+		Type:      "User",
+		SiteAdmin: id.Admin,
+	}
+}
+
+// CanAccess reports whether id may see the sessions of actor.
+func (id Identity) CanAccess(actor common.Actor) bool {
+	return id.Admin || (id.Login != "" && id.Login == actor.Login)
+}
+
+// New assembles the authenticators configured by a tokens file and a JWT
+// secret.  It returns nil if neither is set, meaning authentication is off.
+func New(tokensFile string, jwtSecret string, jwtIssuer string) (Authenticator, error) {
+	chain := Chain{}
+	if tokensFile != "" {
+		st, err := LoadTokenFile(tokensFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, st)
+	}
+	if jwtSecret != "" {
+		chain = append(chain, NewJWTVerifier([]byte(jwtSecret), jwtIssuer))
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
@@ -8,10 +8,14 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"mrvacommander/pkg/metrics"
 	"mrvacommander/utils"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -34,6 +38,57 @@ func getCodeQLCLIPath() (string, error) {
 	return codeqlCliPath, nil
 }
 
+// Bounds of the wait before asking a CLI that failed for its version again
+const (
+	cliVersionMinBackoff = 10 * time.Second
+	cliVersionMaxBackoff = 10 * time.Minute
+)
+
+var (
+	cliVersion      string
+	cliVersionErr   error
+	cliVersionRetry time.Time
+	cliVersionWait  time.Duration
+	cliVersionMutex sync.Mutex
+)
+
+// GetCLIVersion returns the version of the CodeQL CLI named by
+// CODEQL_CLI_PATH, e.g. "2.17.5".  The CLI is only asked once per process.
+// After a failure the error is returned without running the CLI again
+// until a backoff, doubling with every failure, has passed.
+func GetCLIVersion() (string, error) {
+	cliVersionMutex.Lock()
+	defer cliVersionMutex.Unlock()
+	if cliVersion != "" {
+		return cliVersion, nil
+	}
+	if cliVersionErr != nil && time.Now().Before(cliVersionRetry) {
+		return "", cliVersionErr
+	}
+
+	version, err := askCLIVersion()
+	if err != nil {
+		cliVersionWait = min(max(2*cliVersionWait, cliVersionMinBackoff), cliVersionMaxBackoff)
+		cliVersionErr = err
+		cliVersionRetry = time.Now().Add(cliVersionWait)
+		return "", err
+	}
+	cliVersion = version
+	return cliVersion, nil
+}
+
+func askCLIVersion() (string, error) {
+	path, err := getCodeQLCLIPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get codeql cli path: %v", err)
+	}
+	output, err := runCommand([]string{path, "version", "--format=terse"})
+	if err != nil {
+		return "", fmt.Errorf("unable to run codeql version. Error: %v", err)
+	}
+	return strings.TrimSpace(output.Stdout), nil
+}
+
 func GenerateResultsZipArchive(runQueryResult *RunQueryResult) ([]byte, error) {
 	buffer := new(bytes.Buffer)
 	zipWriter := zip.NewWriter(buffer)
@@ -53,6 +108,14 @@ func GenerateResultsZipArchive(runQueryResult *RunQueryResult) ([]byte, error) {
 		}
 	}
 
+	for _, relativePath := range runQueryResult.DecodedFilePaths.RelativeFilePaths {
+		fullPath := filepath.Join(runQueryResult.DecodedFilePaths.BasePath, relativePath)
+		err := addFileToZip(zipWriter, fullPath, relativePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add decoded BQRS file to zip: %v", err)
+		}
+	}
+
 	err := zipWriter.Close()
 	if err != nil {
 		return nil, fmt.Errorf("failed to close zip writer: %v", err)
@@ -82,6 +145,19 @@ func addFileToZip(zipWriter *zip.Writer, filePath, zipPath string) error {
 }
 
 func RunQuery(database string, nwo string, queryPackPath string, tempDir string) (*RunQueryResult, error) {
+	databasePath := filepath.Join(tempDir, "db")
+	start := time.Now()
+	if err := utils.UnzipFile(database, databasePath); err != nil {
+		return nil, fmt.Errorf("failed to unzip database: %v", err)
+	}
+	metrics.ObservePhase(metrics.PhaseUnzip, start)
+
+	return RunQueryOnDatabase(databasePath, nwo, queryPackPath, tempDir)
+}
+
+// RunQueryOnDatabase runs the query pack on an extracted database, writing
+// the results below tempDir.
+func RunQueryOnDatabase(databasePath string, nwo string, queryPackPath string, tempDir string) (*RunQueryResult, error) {
 	path, err := getCodeQLCLIPath()
 
 	if err != nil {
@@ -95,11 +171,6 @@ func RunQuery(database string, nwo string, queryPackPath string, tempDir string)
 		return nil, fmt.Errorf("failed to create results directory: %v", err)
 	}
 
-	databasePath := filepath.Join(tempDir, "db")
-	if utils.UnzipFile(database, databasePath) != nil {
-		return nil, fmt.Errorf("failed to unzip database: %v", err)
-	}
-
 	dbMetadata, err := getDatabaseMetadata(databasePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database metadata: %v", err)
@@ -115,10 +186,15 @@ func RunQuery(database string, nwo string, queryPackPath string, tempDir string)
 		databaseSHA = *dbMetadata.CreationMetadata.SHA
 	}
 
+	start := time.Now()
 	cmd := exec.Command(codeql.Path, "database", "run-queries", "--ram=2048", "--additional-packs", queryPackPath, "--", databasePath, queryPackPath)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return nil, fmt.Errorf("failed to run queries: %v\nOutput: %s", err, output)
 	}
+	metrics.ObservePhase(metrics.PhaseRunQueries, start)
+
+	// Interpretation covers SARIF generation and BQRS decoding
+	start = time.Now()
 
 	queryPackRunResults, err := getQueryPackRunResults(codeql, databasePath, queryPackPath)
 	if err != nil {
@@ -163,11 +239,19 @@ func RunQuery(database string, nwo string, queryPackPath string, tempDir string)
 		return nil, fmt.Errorf("failed to adjust BQRS files: %v", err)
 	}
 
+	slog.Debug("Decoding BQRS files")
+	decodedFilePaths, err := decodeBqrsFiles(codeql, queryPackRunResults, bqrsFilePaths, resultsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode BQRS files: %v", err)
+	}
+	metrics.ObservePhase(metrics.PhaseInterpret, start)
+
 	return &RunQueryResult{
 		ResultCount:          resultCount,
 		DatabaseSHA:          databaseSHA,
 		SourceLocationPrefix: sourceLocationPrefix,
 		BqrsFilePaths:        bqrsFilePaths,
+		DecodedFilePaths:     decodedFilePaths,
 		SarifFilePath:        sarifFilePath,
 	}, nil
 }
@@ -309,6 +393,51 @@ func adjustBqrsFiles(queryPackRunResults *QueryPackRunResults, resultsDir string
 	}, nil
 }
 
+// DecodedFileName returns the archive path of the decoding of one result
+// set of a BQRS file.  The #select result set keeps the BQRS base name, so
+// results.bqrs decodes to results.csv; other result sets get their name
+// appended, as in results.edges.csv.
+func DecodedFileName(relativeBqrsPath, resultSet, format string) string {
+	base := strings.TrimSuffix(relativeBqrsPath, ".bqrs")
+	if resultSet != SelectResultSetName {
+		base += "." + strings.TrimPrefix(resultSet, "#")
+	}
+	return base + "." + format
+}
+
+// decodeBqrsFiles runs `codeql bqrs decode` for every result set of every
+// BQRS file in every format of DecodeFormats, writing the output below
+// resultsDir.
+func decodeBqrsFiles(codeql CodeqlCli, queryPackRunResults *QueryPackRunResults, bqrsFilePaths BqrsFilePaths, resultsDir string) (BqrsFilePaths, error) {
+	decoded := BqrsFilePaths{BasePath: resultsDir, RelativeFilePaths: []string{}}
+
+	for i, relativePath := range bqrsFilePaths.RelativeFilePaths {
+		bqrsPath := filepath.Join(bqrsFilePaths.BasePath, relativePath)
+		bqrsInfo := queryPackRunResults.Queries[i].BqrsInfo
+
+		for _, resultSet := range bqrsInfo.ResultSets {
+			for _, format := range DecodeFormats {
+				relativeOutput := DecodedFileName(relativePath, resultSet.Name, format)
+				output := filepath.Join(resultsDir, relativeOutput)
+				if err := os.MkdirAll(filepath.Dir(output), os.ModePerm); err != nil {
+					return BqrsFilePaths{}, err
+				}
+
+				cmd := exec.Command(codeql.Path, "bqrs", "decode",
+					"--format="+format, "--result-set="+resultSet.Name,
+					"--output="+output, "--", bqrsPath)
+				if out, err := cmd.CombinedOutput(); err != nil {
+					return BqrsFilePaths{}, fmt.Errorf("failed to decode result set %s of %s: %v\nOutput: %s",
+						resultSet.Name, relativePath, err, out)
+				}
+				decoded.RelativeFilePaths = append(decoded.RelativeFilePaths, relativeOutput)
+			}
+		}
+	}
+
+	return decoded, nil
+}
+
 func getSourceLocationPrefix(codeql CodeqlCli, databasePath string) (string, error) {
 	cmd := exec.Command(codeql.Path, "resolve", "database", databasePath)
 	output, err := cmd.CombinedOutput()
@@ -397,7 +526,13 @@ func getSarifResultCount(sarif []byte) int {
 }
 
 // Known result set names
-var KnownResultSetNames = []string{"#select", "problems"}
+var KnownResultSetNames = []string{SelectResultSetName, "problems"}
+
+// The result set holding the rows of a query's select clause
+const SelectResultSetName = "#select"
+
+// Formats each BQRS result set is decoded to
+var DecodeFormats = []string{"csv", "json"}
 
 // getBqrssResultCount returns the number of results in the BQRS file.
 func getBqrsResultCount(bqrsInfo BQRSInfo) (int, error) {
@@ -10,6 +10,7 @@ type RunQueryResult struct {
 	DatabaseSHA          string
 	SourceLocationPrefix string
 	BqrsFilePaths        BqrsFilePaths
+	DecodedFilePaths     BqrsFilePaths
 	SarifFilePath        string
 }
 
@@ -50,22 +50,24 @@ type StatusResponse struct {
 }
 
 type ControllerRepo struct {
-	ID               int      `json:"id"`
-	NodeID           string   `json:"node_id"`
-	Name             string   `json:"name"`
-	FullName         string   `json:"full_name"`
-	Private          bool     `json:"private"`
-	Owner            struct{} `json:"owner"`
-	HTMLURL          string   `json:"html_url"`
-	Description      string   `json:"description"`
-	Fork             bool     `json:"fork"`
-	ForksURL         string   `json:"forks_url"`
-	KeysURL          string   `json:"keys_url"`
-	CollaboratorsURL string   `json:"collaborators_url"`
-	TeamsURL         string   `json:"teams_url"`
-	HooksURL         string   `json:"hooks_url"`
-	IssueEventsURL   string   `json:"issue_events_url"`
-	EventsURL        string   `json:"events_url"`
+	ID               int    `json:"id"`
+	NodeID           string `json:"node_id"`
+	Name             string `json:"name"`
+	FullName         string `json:"full_name"`
+	Visibility       string `json:"visibility"`
+	Private          bool   `json:"private"`
+	Owner            Actor  `json:"owner"`
+	HTMLURL          string `json:"html_url"`
+	Description      string `json:"description"`
+	Fork             bool   `json:"fork"`
+	URL              string `json:"url"`
+	ForksURL         string `json:"forks_url"`
+	KeysURL          string `json:"keys_url"`
+	CollaboratorsURL string `json:"collaborators_url"`
+	TeamsURL         string `json:"teams_url"`
+	HooksURL         string `json:"hooks_url"`
+	IssueEventsURL   string `json:"issue_events_url"`
+	EventsURL        string `json:"events_url"`
 
 	AssigneesURL string `json:"assignees_url"`
 	BranchesURL  string `json:"branches_url"`
@@ -105,6 +107,7 @@ type ScannedRepo struct {
 	AnalysisStatus    string     `json:"analysis_status"`
 	ResultCount       int        `json:"result_count"`
 	ArtifactSizeBytes int        `json:"artifact_size_in_bytes"`
+	CacheHit          bool       `json:"cache_hit,omitempty"`
 }
 
 type Repository struct {
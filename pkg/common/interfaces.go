@@ -1,4 +1,12 @@
 package common
 
+import "context"
+
 type Common interface {
 }
+
+// HealthChecker is implemented by modules that depend on an external
+// service or resource and can tell whether it is usable.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
@@ -1,5 +1,33 @@
 package common
 
+import "time"
+
+// Session holds what the server records about a variant analysis session
+// as a whole, as opposed to the per-repository jobs it consists of.
+type Session struct {
+	ID             int
+	Owner          string
+	ControllerRepo string
+	Actor          Actor
+	QueryPack      string
+	QueryPackHash  string
+	Language       string
+	Repositories   []NameWithOwner
+	// Commit SHA (or prefix) of the database to analyze, for repositories
+	// submitted as owner/repo@sha
+	Pins      map[NameWithOwner]string
+	CreatedAt time.Time
+}
+
+// Controller is a registered controller repository, the repository that
+// variant analyses are submitted against.
+type Controller struct {
+	ID         int    `json:"id"`
+	Owner      string `json:"owner"`
+	Name       string `json:"name"`
+	Visibility string `json:"visibility"`
+}
+
 // NameWithOwner represents a repository name and its owner name.
 type NameWithOwner struct {
 	Owner string
@@ -9,21 +37,45 @@ type NameWithOwner struct {
 // AnalyzeJob represents a job specifying a repository and a query pack to analyze it with.
 // This is the message format that the agent receives from the queue.
 type AnalyzeJob struct {
-	RequestId     int           // json:"request_id"
-	QueryPackId   int           // json:"query_pack_id"
-	QueryPackURL  string        // json:"query_pack_url"
-	QueryLanguage string        // json:"query_language"
-	NWO           NameWithOwner // json:"nwo"
+	RequestId        int           // json:"request_id"
+	QueryPackId      int           // json:"query_pack_id"
+	QueryPackURL     string        // json:"query_pack_url"
+	QueryPackHash    string        // json:"query_pack_hash"
+	QueryLanguage    string        // json:"query_language"
+	NWO              NameWithOwner // json:"nwo"
+	DatabaseChecksum string        // json:"database_checksum"
+	DatabasePath     string        // json:"database_path"
 }
 
 // AnalyzeResult represents the result of an analysis job.
 // This is the message format that the agent sends to the queue.
 // Status will only ever be StatusSuccess or StatusError when sent in a result.
 type AnalyzeResult struct {
-	Status           Status // json:"status"
-	RequestId        int    // json:"request_id"
-	ResultCount      int    // json:"result_count"
-	ResultArchiveURL string // json:"result_archive_url"
+	Status           Status        // json:"status"
+	RequestId        int           // json:"request_id"
+	QueryPackId      int           // json:"query_pack_id"
+	NWO              NameWithOwner // json:"nwo"
+	ResultCount      int           // json:"result_count"
+	ResultArchiveURL string        // json:"result_archive_url"
+	CacheKey         CacheKey      // json:"cache_key"
+	CacheHit         bool          // json:"cache_hit"
+	// Contents of the results zip archive of a successful analysis.  The
+	// server stores the archive and drops it from the recorded result.
+	ResultArchive []byte // json:"result_archive"
+}
+
+// CacheKey identifies an analysis whose result can be reused: the same query
+// pack contents run on the same database by the same CodeQL CLI version.
+type CacheKey struct {
+	QueryPackHash    string // json:"query_pack_hash"
+	DatabaseChecksum string // json:"database_checksum"
+	CLIVersion       string // json:"cli_version"
+}
+
+// Complete reports whether every part of the key is known.  Incomplete keys
+// must never be used for caching.
+func (k CacheKey) Complete() bool {
+	return k.QueryPackHash != "" && k.DatabaseChecksum != "" && k.CLIVersion != ""
 }
 
 // Status represents the status of a job.
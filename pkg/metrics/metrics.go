@@ -0,0 +1,122 @@
+// Package metrics defines the Prometheus metrics of the server and the
+// agents.  Both register into the default registry and serve it with
+// Handler.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mrva"
+
+// Repository outcomes counted by Repositories
+const (
+	RepoQueued    = "queued"
+	RepoCached    = "cached"
+	RepoSucceeded = "succeeded"
+	RepoFailed    = "failed"
+)
+
+// Phases of an analysis job observed by PhaseDuration
+const (
+	PhaseUnzip      = "unzip"
+	PhaseRunQueries = "run_queries"
+	PhaseInterpret  = "interpret"
+)
+
+var (
+	// Server
+
+	Submissions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "submissions_total",
+		Help:      "Variant analysis submissions by outcome (accepted, rejected).",
+	}, []string{"outcome"})
+
+	Repositories = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "repositories_total",
+		Help:      "Repository analyses by language and status (queued, cached, succeeded, failed).",
+	}, []string{"language", "status"})
+
+	ResultArchiveBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "result_archive_bytes",
+		Help:      "Size of stored result archives.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10), // 1 KiB .. 256 MiB
+	})
+
+	// Agent
+
+	Workers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "agent_workers",
+		Help:      "Number of agent workers.",
+	})
+
+	BusyWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "agent_busy_workers",
+		Help:      "Number of agent workers running a job.",
+	})
+
+	JobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Duration of analysis jobs by status.",
+		Buckets:   durationBuckets,
+	}, []string{"status"})
+
+	PhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_phase_duration_seconds",
+		Help:      "Duration of the phases of analysis jobs.",
+		Buckets:   durationBuckets,
+	}, []string{"phase"})
+
+	DBCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "agent_db_cache_lookups_total",
+		Help:      "Lookups in the agent database cache by result (hit, miss).",
+	}, []string{"result"})
+
+	DBCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "agent_db_cache_bytes",
+		Help:      "Size of the extracted databases in the agent database cache.",
+	})
+)
+
+// 0.5s .. ~4.5h
+var durationBuckets = prometheus.ExponentialBuckets(0.5, 2.5, 11)
+
+// RegisterQueueDepth exports the number of jobs waiting for or being
+// analyzed, as reported by depth at scrape time.  Only the first call has an
+// effect.
+func RegisterQueueDepth(depth func() float64) {
+	err := prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of repository analyses queued or in progress.",
+	}, depth))
+	var are prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &are) {
+		panic(err)
+	}
+}
+
+// ObservePhase records the duration of a job phase started at start.
+func ObservePhase(phase string, start time.Time) {
+	PhaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,354 @@
+package qldbimport
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/qldbstore"
+	"mrvacommander/utils"
+)
+
+// Item is one database to import, as given by a manifest line.  Exactly one
+// of Path and URL is set; relative paths are relative to the manifest.
+type Item struct {
+	Owner    string `json:"owner"`
+	Repo     string `json:"repo"`
+	Language string `json:"language,omitempty"`
+	Path     string `json:"path,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// Source names the archive of the item in records and the state file.
+func (it Item) Source() string {
+	if it.URL != "" {
+		return it.URL
+	}
+	return it.Path
+}
+
+// Outcomes of importing an item
+const (
+	OutcomeImported  = "imported"
+	OutcomeDuplicate = "duplicate"
+	OutcomeInvalid   = "invalid"
+	OutcomeFailed    = "failed"
+)
+
+// Record is the outcome of an item, appended to the state file as it
+// completes so an interrupted import can be resumed.
+type Record struct {
+	Source   string `json:"source"`
+	Owner    string `json:"owner"`
+	Repo     string `json:"repo"`
+	Outcome  string `json:"outcome"`
+	Checksum string `json:"checksum,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report summarizes an import run.
+type Report struct {
+	Imported  int      `json:"imported"`
+	Duplicate int      `json:"duplicate"`
+	Resumed   int      `json:"resumed"`
+	Invalid   []Record `json:"invalid"`
+	Failed    []Record `json:"failed"`
+}
+
+// WriteReport writes r as JSON to fname.
+func WriteReport(fname string, r Report) error {
+	buf, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, buf, 0644)
+}
+
+// Registrar stores databases, either directly in a local store or through
+// the server's upload API.  Errors that reject the archive itself wrap
+// qldbstore.ErrInvalidDatabase.
+type Registrar interface {
+	Register(nwo common.NameWithOwner, fname, language string) (qldbstore.DBInfo, error)
+	// Known reports whether an archive with the given sha256 is stored
+	Known(sum string) (bool, error)
+}
+
+// LocalStore registers databases in a store on this host.
+type LocalStore struct {
+	Store *qldbstore.StorageQLDB
+}
+
+func (l LocalStore) Register(nwo common.NameWithOwner, fname, language string) (qldbstore.DBInfo, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return qldbstore.DBInfo{}, err
+	}
+	defer f.Close()
+	return l.Store.Add(nwo, f, language, 0)
+}
+
+func (l LocalStore) Known(sum string) (bool, error) {
+	_, ok := l.Store.FindChecksum(sum)
+	return ok, nil
+}
+
+// WalkDir lists the database archives below dir, laid out as
+// {owner}/{repo}/*.zip.
+func WalkDir(dir string) ([]Item, error) {
+	items := []Item{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".zip") || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) < 3 {
+			slog.Warn("Skipping archive not below {owner}/{repo}", "path", p)
+			return nil
+		}
+		items = append(items, Item{Owner: parts[0], Repo: parts[1], Path: p})
+		return nil
+	})
+	return items, err
+}
+
+// ReadManifest reads the items of a JSONL manifest.
+func ReadManifest(fname string) ([]Item, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items := []Item{}
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		var it Item
+		if err := json.Unmarshal([]byte(text), &it); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", fname, line, err)
+		}
+		if it.Owner == "" || it.Repo == "" || (it.Path == "") == (it.URL == "") {
+			return nil, fmt.Errorf("%s:%d: needs owner, repo and one of path and url", fname, line)
+		}
+		if it.Path != "" && !filepath.IsAbs(it.Path) {
+			it.Path = filepath.Join(filepath.Dir(fname), it.Path)
+		}
+		items = append(items, it)
+	}
+	return items, sc.Err()
+}
+
+// Importer registers items concurrently, skipping those the state file
+// records as done and archives already stored.
+type Importer struct {
+	reg Registrar
+
+	mutex     sync.Mutex
+	state     *os.File
+	done      map[string]bool   // sources finished in earlier runs
+	checksums map[string]string // stored or claimed checksums, by sha256
+	report    Report
+}
+
+// NewImporter resumes the import recorded in the state file at statePath,
+// which is created if it does not exist.
+func NewImporter(reg Registrar, statePath string) (*Importer, error) {
+	imp := Importer{
+		reg:       reg,
+		done:      map[string]bool{},
+		checksums: map[string]string{},
+		report:    Report{Invalid: []Record{}, Failed: []Record{}},
+	}
+	if buf, err := os.ReadFile(statePath); err == nil {
+		for _, line := range strings.Split(string(buf), "\n") {
+			var rec Record
+			if json.Unmarshal([]byte(line), &rec) != nil {
+				continue
+			}
+			// Invalid and failed archives are tried again
+			if rec.Outcome == OutcomeImported || rec.Outcome == OutcomeDuplicate {
+				imp.done[rec.Source] = true
+			}
+			if rec.Outcome == OutcomeImported && rec.Checksum != "" {
+				imp.checksums[rec.Checksum] = rec.Source
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(statePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	imp.state = f
+	return &imp, nil
+}
+
+// Close closes the state file.
+func (imp *Importer) Close() error {
+	return imp.state.Close()
+}
+
+// Run imports items, jobs at a time, and reports the outcomes.
+func (imp *Importer) Run(items []Item, jobs int) Report {
+	work := make(chan Item)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range work {
+				imp.record(imp.importOne(it))
+			}
+		}()
+	}
+	for _, it := range items {
+		if imp.done[it.Source()] {
+			imp.report.Resumed++
+			continue
+		}
+		work <- it
+	}
+	close(work)
+	wg.Wait()
+
+	sortRecords := func(recs []Record) {
+		sort.Slice(recs, func(i, j int) bool { return recs[i].Source < recs[j].Source })
+	}
+	sortRecords(imp.report.Invalid)
+	sortRecords(imp.report.Failed)
+	return imp.report
+}
+
+func (imp *Importer) importOne(it Item) Record {
+	rec := Record{Source: it.Source(), Owner: it.Owner, Repo: it.Repo}
+	fail := func(err error) Record {
+		rec.Outcome = OutcomeFailed
+		if errors.Is(err, qldbstore.ErrInvalidDatabase) {
+			rec.Outcome = OutcomeInvalid
+		}
+		rec.Error = err.Error()
+		return rec
+	}
+
+	fname := it.Path
+	if it.URL != "" {
+		tmp, err := download(it.URL)
+		if err != nil {
+			return fail(err)
+		}
+		defer os.Remove(tmp)
+		fname = tmp
+	}
+
+	sum, err := utils.FileChecksum(fname)
+	if err != nil {
+		return fail(err)
+	}
+	rec.Checksum = sum
+
+	// Claim the checksum so concurrent copies of an archive import once
+	imp.mutex.Lock()
+	_, dup := imp.checksums[sum]
+	if !dup {
+		imp.checksums[sum] = rec.Source
+	}
+	imp.mutex.Unlock()
+	if !dup {
+		known, err := imp.reg.Known(sum)
+		if err != nil {
+			imp.release(sum)
+			return fail(err)
+		}
+		dup = known
+	}
+	if dup {
+		rec.Outcome = OutcomeDuplicate
+		return rec
+	}
+
+	info, err := imp.reg.Register(common.NameWithOwner{Owner: it.Owner, Repo: it.Repo}, fname, it.Language)
+	if err != nil {
+		imp.release(sum)
+		return fail(err)
+	}
+	rec.Outcome = OutcomeImported
+	rec.Path = info.Path
+	return rec
+}
+
+// release gives up the claim on a checksum whose archive was not imported.
+func (imp *Importer) release(sum string) {
+	imp.mutex.Lock()
+	defer imp.mutex.Unlock()
+	delete(imp.checksums, sum)
+}
+
+// record adds the outcome of an item to the report and the state file.
+func (imp *Importer) record(rec Record) {
+	imp.mutex.Lock()
+	defer imp.mutex.Unlock()
+	switch rec.Outcome {
+	case OutcomeImported:
+		imp.report.Imported++
+	case OutcomeDuplicate:
+		imp.report.Duplicate++
+	case OutcomeInvalid:
+		imp.report.Invalid = append(imp.report.Invalid, rec)
+	default:
+		imp.report.Failed = append(imp.report.Failed, rec)
+	}
+	buf, _ := json.Marshal(rec)
+	if _, err := imp.state.Write(append(buf, '\n')); err != nil {
+		slog.Error("Unable to record import state", "error", err)
+	}
+}
+
+// download fetches url into a temporary file and returns its name.
+func download(url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download of %s failed: %s", url, resp.Status)
+	}
+	f, err := os.CreateTemp("", "qldb-import-*.zip")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,320 @@
+package qldbstore
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/utils"
+)
+
+const (
+	indexFileName = "index.json"
+	indexVersion  = 1
+	metadataFile  = "codeql-database.yml"
+)
+
+func (s *StorageQLDB) indexPath() string {
+	return filepath.Join(s.root, indexFileName)
+}
+
+// loadIndex reads the persisted index; a missing index is empty.
+func (s *StorageQLDB) loadIndex() error {
+	buf, err := os.ReadFile(s.indexPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f indexFile
+	if err := json.Unmarshal(buf, &f); err != nil || f.Version != indexVersion {
+		slog.Warn("Ignoring unreadable database index, rebuilding", "path", s.indexPath(), "error", err)
+		return nil
+	}
+	for _, info := range f.Databases {
+		s.index[info.Path] = info
+	}
+	return nil
+}
+
+// Bounds of waiting for the index lock, and the age after which a lock is
+// taken to be left by a crashed process
+const (
+	indexLockTimeout = 10 * time.Second
+	staleIndexLock   = time.Minute
+)
+
+// saveIndexLocked writes the index.  Other processes sharing the store, such
+// as qldb import next to the server, write it too, so their entries for
+// archives this process has not seen yet are merged in first.
+func (s *StorageQLDB) saveIndexLocked() error {
+	unlock, err := s.lockIndexFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	s.mergeIndexLocked()
+
+	f := indexFile{Version: indexVersion, Databases: make([]DBInfo, 0, len(s.index))}
+	for _, info := range s.index {
+		f.Databases = append(f.Databases, info)
+	}
+	sort.Slice(f.Databases, func(i, j int) bool { return f.Databases[i].Path < f.Databases[j].Path })
+	buf, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write atomically so a crash never leaves a truncated index
+	tmp, err := os.CreateTemp(s.root, indexFileName+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.indexPath())
+}
+
+// mergeIndexLocked adds the entries of the index file for archives that are
+// missing from the index in memory and unchanged on disk since.
+func (s *StorageQLDB) mergeIndexLocked() {
+	buf, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		return
+	}
+	var f indexFile
+	if err := json.Unmarshal(buf, &f); err != nil || f.Version != indexVersion {
+		return
+	}
+	for _, info := range f.Databases {
+		if _, ok := s.index[info.Path]; ok {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(s.root, info.Path))
+		if err == nil && fi.Size() == info.Size && fi.ModTime().Equal(info.ModTime) {
+			s.index[info.Path] = info
+		}
+	}
+}
+
+// lockIndexFile takes the lock file guarding updates of the index against
+// other processes and returns the function releasing it.
+func (s *StorageQLDB) lockIndexFile() (func(), error) {
+	lock := s.indexPath() + ".lock"
+	deadline := time.Now().Add(indexLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > staleIndexLock {
+			slog.Warn("Removing stale database index lock", "path", lock)
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("database index %s is locked", s.indexPath())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// CheckHealth verifies that the store root is a directory the index can be
+// written to and that an existing index can be read.
+func (s *StorageQLDB) CheckHealth(ctx context.Context) error {
+	if fi, err := os.Stat(s.root); err != nil || !fi.IsDir() {
+		return fmt.Errorf("database store %s not available", s.root)
+	}
+	f, err := os.CreateTemp(s.root, ".health-*")
+	if err != nil {
+		return fmt.Errorf("database store %s not writable: %w", s.root, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	f, err = os.Open(s.indexPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("database index not readable: %w", err)
+	}
+	return f.Close()
+}
+
+// Reindex walks the store, adding new archives to the index, re-reading
+// changed ones and dropping those that disappeared.
+func (s *StorageQLDB) Reindex() error {
+	if _, err := os.Stat(s.root); errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Database store does not exist", "root", s.root)
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reindexed = time.Now()
+
+	seen := map[string]bool{}
+	changed := false
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".zip") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		// Archives live below {owner}/{repo}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) < 3 {
+			return nil
+		}
+		seen[rel] = true
+		_, _, updated := s.refreshLocked(rel, common.NameWithOwner{Owner: parts[0], Repo: parts[1]}, "")
+		changed = changed || updated
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index database store %s: %w", s.root, err)
+	}
+	for rel := range s.index {
+		if !seen[rel] {
+			delete(s.index, rel)
+			changed = true
+		}
+	}
+	slog.Info("Indexed database store", "root", s.root, "databases", len(s.index))
+	if changed {
+		return s.saveIndexLocked()
+	}
+	return nil
+}
+
+// refreshLocked brings the index entry of the archive at rel up to date.
+// The archive is hashed when it is new or changed, unless its checksum is
+// already known.  It reports whether the archive exists and whether the
+// entry changed.
+func (s *StorageQLDB) refreshLocked(rel string, nwo common.NameWithOwner, checksum string) (DBInfo, bool, bool) {
+	fi, err := os.Stat(filepath.Join(s.root, rel))
+	if err != nil {
+		_, had := s.index[rel]
+		delete(s.index, rel)
+		return DBInfo{}, false, had
+	}
+	if info, ok := s.index[rel]; ok && info.Size == fi.Size() && info.ModTime.Equal(fi.ModTime()) {
+		if info.Checksum != "" {
+			return info, true, false
+		}
+		// Entry of an index written before checksums were recorded
+		info.Checksum = archiveChecksum(filepath.Join(s.root, rel), checksum)
+		s.index[rel] = info
+		return info, true, info.Checksum != ""
+	}
+
+	info := DBInfo{
+		Owner:    nwo.Owner,
+		Repo:     nwo.Repo,
+		Path:     rel,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+		Checksum: archiveChecksum(filepath.Join(s.root, rel), checksum),
+	}
+	md, err := readArchiveMetadata(filepath.Join(s.root, rel))
+	if err != nil {
+		slog.Warn("Unable to read database metadata", "path", rel, "error", err)
+		info.Error = err.Error()
+	} else {
+		info.Language = md.PrimaryLanguage
+		info.SHA = md.CreationMetadata.SHA
+		info.CLIVersion = md.CreationMetadata.CLIVersion
+		info.CreationTime = md.CreationMetadata.CreationTime
+	}
+	s.index[rel] = info
+	return info, true, true
+}
+
+// archiveChecksum returns known, or else the sha256 of the archive at p,
+// which is "" if the archive cannot be read.
+func archiveChecksum(p, known string) string {
+	if known != "" {
+		return known
+	}
+	sum, err := utils.FileChecksum(p)
+	if err != nil {
+		slog.Warn("Unable to checksum database", "path", p, "error", err)
+		return ""
+	}
+	return sum
+}
+
+// readArchiveMetadata reads codeql-database.yml from a database archive.
+func readArchiveMetadata(zipPath string) (databaseYml, error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return databaseYml{}, err
+	}
+	defer zr.Close()
+	md, _, err := archiveMetadata(&zr.Reader)
+	return md, err
+}
+
+// archiveMetadata reads codeql-database.yml, either at the top level of the
+// archive or in the single directory the database was bundled in, and
+// returns it with that directory.
+func archiveMetadata(zr *zip.Reader) (databaseYml, string, error) {
+	var md databaseYml
+	var found *zip.File
+	for _, f := range zr.File {
+		name := strings.TrimPrefix(f.Name, "./")
+		if path.Base(name) != metadataFile || strings.Count(name, "/") > 1 {
+			continue
+		}
+		if found == nil || strings.Count(name, "/") < strings.Count(found.Name, "/") {
+			found = f
+		}
+	}
+	if found == nil {
+		return md, "", fmt.Errorf("%s not found in archive", metadataFile)
+	}
+
+	rc, err := found.Open()
+	if err != nil {
+		return md, "", err
+	}
+	defer rc.Close()
+	buf, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+	if err != nil {
+		return md, "", err
+	}
+	if err := yaml.Unmarshal(buf, &md); err != nil {
+		return md, "", fmt.Errorf("invalid %s: %w", metadataFile, err)
+	}
+	return md, path.Dir(strings.TrimPrefix(found.Name, "./")), nil
+}
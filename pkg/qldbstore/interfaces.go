@@ -1,32 +1,26 @@
 package qldbstore
 
 import (
+	"io"
+
 	"mrvacommander/pkg/common"
 )
 
-type DBLocation struct {
-	Prefix string
-	File   string
-}
-
 type Storage interface {
-	FindAvailableDBs(analysisReposRequested []common.NameWithOwner) (not_found_repos []common.NameWithOwner,
+	// FindAvailableDBs chooses a database for each requested repository:
+	// the newest one for language (any language if empty) or, if the
+	// repository is in pins, the newest one of the pinned commit.
+	// Repositories without any database are not found; those without a
+	// matching one are reported as having no usable database.
+	FindAvailableDBs(analysisReposRequested []common.NameWithOwner, language string,
+		pins map[common.NameWithOwner]string) (not_found_repos []common.NameWithOwner,
+		no_db_repos []common.NameWithOwner,
 		analysisRepos *map[common.NameWithOwner]DBLocation)
-}
-
-type Visibles struct{}
-
-type StorageQLDB struct{}
-
-func NewStore(v *Visibles) (Storage, error) {
-	s := StorageQLDB{}
-
-	return &s, nil
-}
 
-func (s *StorageQLDB) FindAvailableDBs(analysisReposRequested []common.NameWithOwner) (
-	not_found_repos []common.NameWithOwner,
-	analysisRepos *map[common.NameWithOwner]DBLocation) {
-	// TODO implement
-	return nil, nil
+	// List returns the index entries of all databases, by repository and
+	// newest first.
+	List() []DBInfo
+	// Add stores and indexes a database archive of nwo, see
+	// StorageQLDB.Add.
+	Add(nwo common.NameWithOwner, r io.Reader, language string, maxBytes int64) (DBInfo, error)
 }
@@ -0,0 +1,220 @@
+package qldbstore
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"mrvacommander/pkg/common"
+)
+
+// NewStore opens the database store under codeql/dbs in the working
+// directory.
+func NewStore(v *Visibles) (Storage, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return NewStoreAt(filepath.Join(cwd, "codeql", "dbs"))
+}
+
+// NewStoreAt opens the database store under root and brings its index up
+// to date.
+func NewStoreAt(root string) (*StorageQLDB, error) {
+	s := StorageQLDB{root: root, index: map[string]DBInfo{}}
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	if err := s.Reindex(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// StorePath returns where a database of nwo for language and commit sha is
+// kept, relative to the store root.  Databases of the older layout,
+// {owner}/{repo}/{owner}_{repo}_db.zip, are still found.
+func StorePath(nwo common.NameWithOwner, language, sha string) string {
+	if language == "" {
+		language = "unknown"
+	}
+	if sha == "" {
+		sha = "unknown"
+	}
+	return filepath.Join(nwo.Owner, nwo.Repo, language, sha+".zip")
+}
+
+func (s *StorageQLDB) FindAvailableDBs(analysisReposRequested []common.NameWithOwner, language string,
+	pins map[common.NameWithOwner]string) (
+	not_found_repos []common.NameWithOwner,
+	no_db_repos []common.NameWithOwner,
+	analysisRepos *map[common.NameWithOwner]DBLocation) {
+	slog.Debug("Looking for available CodeQL databases", "language", language)
+
+	analysisRepos = &map[common.NameWithOwner]DBLocation{}
+	not_found_repos = []common.NameWithOwner{}
+	no_db_repos = []common.NameWithOwner{}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	changed := false
+	for _, rep := range analysisReposRequested {
+		// The names become directories below the store root
+		if !ValidName(rep.Owner) || !ValidName(rep.Repo) {
+			slog.Warn("Invalid owner or repository name", "owner/repo", rep)
+			not_found_repos = append(not_found_repos, rep)
+			continue
+		}
+		dbs, updated := s.refreshRepoLocked(rep)
+		changed = changed || updated
+		if len(dbs) == 0 {
+			slog.Info("Database does not exist for repository ", "owner/repo", rep)
+			not_found_repos = append(not_found_repos, rep)
+			continue
+		}
+		info, ok := selectDB(dbs, language, pins[rep])
+		if !ok {
+			slog.Info("No database matches the request", "owner/repo", rep,
+				"language", language, "commit", pins[rep], "databases", len(dbs))
+			no_db_repos = append(no_db_repos, rep)
+			continue
+		}
+		slog.Info("Found database for ", "owner/repo", rep, "path", info.Path,
+			"language", info.Language, "commit", info.SHA)
+		(*analysisRepos)[rep] = DBLocation{
+			Prefix:   filepath.Join(s.root, filepath.Dir(info.Path)),
+			File:     filepath.Base(info.Path),
+			Checksum: info.Checksum,
+		}
+	}
+	if changed {
+		if err := s.saveIndexLocked(); err != nil {
+			slog.Warn("Unable to save database index", "error", err)
+		}
+	}
+	return not_found_repos, no_db_repos, analysisRepos
+}
+
+// selectDB returns the newest of dbs for language, and of commit pin if set.
+// Databases whose metadata could not be read match any language but no pin.
+func selectDB(dbs []DBInfo, language, pin string) (DBInfo, bool) {
+	for _, info := range dbs {
+		if language != "" && info.Language != "" && !strings.EqualFold(info.Language, language) {
+			continue
+		}
+		if pin != "" && (info.SHA == "" || !strings.HasPrefix(strings.ToLower(info.SHA), strings.ToLower(pin))) {
+			continue
+		}
+		return info, true
+	}
+	return DBInfo{}, false
+}
+
+// Created returns when the database was created, falling back to the time
+// the archive was stored.
+func (info DBInfo) Created() time.Time {
+	if !info.CreationTime.IsZero() {
+		return info.CreationTime
+	}
+	return info.ModTime
+}
+
+// newestFirst orders databases by creation time.
+func newestFirst(dbs []DBInfo) {
+	sort.SliceStable(dbs, func(i, j int) bool {
+		if !dbs[i].Created().Equal(dbs[j].Created()) {
+			return dbs[i].Created().After(dbs[j].Created())
+		}
+		return dbs[i].Path < dbs[j].Path
+	})
+}
+
+// Databases copied into the store by other processes, such as qldb import,
+// are found by a lookup of their repository; listings pick them up by
+// reindexing at most this often.
+const listReindexInterval = time.Minute
+
+func (s *StorageQLDB) List() []DBInfo {
+	s.mutex.Lock()
+	stale := time.Since(s.reindexed) > listReindexInterval
+	s.mutex.Unlock()
+	if stale {
+		if err := s.Reindex(); err != nil {
+			slog.Warn("Unable to reindex database store", "error", err)
+		}
+	}
+
+	s.mutex.Lock()
+	dbs := make([]DBInfo, 0, len(s.index))
+	for _, info := range s.index {
+		dbs = append(dbs, info)
+	}
+	s.mutex.Unlock()
+
+	newestFirst(dbs)
+	sort.SliceStable(dbs, func(i, j int) bool {
+		if dbs[i].Owner != dbs[j].Owner {
+			return dbs[i].Owner < dbs[j].Owner
+		}
+		return dbs[i].Repo < dbs[j].Repo
+	})
+	return dbs
+}
+
+// Databases returns the index entries of the databases of nwo, newest first.
+func (s *StorageQLDB) Databases(nwo common.NameWithOwner) []DBInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.repoEntriesLocked(nwo)
+}
+
+func (s *StorageQLDB) repoEntriesLocked(nwo common.NameWithOwner) []DBInfo {
+	dbs := []DBInfo{}
+	for _, info := range s.index {
+		if info.Owner == nwo.Owner && info.Repo == nwo.Repo {
+			dbs = append(dbs, info)
+		}
+	}
+	newestFirst(dbs)
+	return dbs
+}
+
+// refreshRepoLocked brings the index entries of nwo up to date with the
+// archives on disk and returns them, newest first.  It also reports whether
+// the index changed.
+func (s *StorageQLDB) refreshRepoLocked(nwo common.NameWithOwner) ([]DBInfo, bool) {
+	changed := false
+	seen := map[string]bool{}
+	dir := filepath.Join(s.root, nwo.Owner, nwo.Repo)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".zip") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		seen[rel] = true
+		_, _, updated := s.refreshLocked(rel, nwo, "")
+		changed = changed || updated
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Unable to scan databases", "owner/repo", nwo, "error", err)
+	}
+	for rel, info := range s.index {
+		if info.Owner == nwo.Owner && info.Repo == nwo.Repo && !seen[rel] {
+			delete(s.index, rel)
+			changed = true
+		}
+	}
+	return s.repoEntriesLocked(nwo), changed
+}
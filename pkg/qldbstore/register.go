@@ -0,0 +1,148 @@
+package qldbstore
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"mrvacommander/pkg/common"
+)
+
+// ErrInvalidDatabase is wrapped by the errors of Add that are due to the
+// archive rather than the store.
+var ErrInvalidDatabase = errors.New("invalid database")
+
+// namePattern matches the owner and repository names accepted by Add.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidName reports whether name may be used as an owner or repository name
+// in the store, where it becomes a directory.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name) && name != "." && name != ".."
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidDatabase, fmt.Sprintf(format, args...))
+}
+
+// Add stores the database archive read from r as a database of nwo,
+// replacing one of the same language and commit, and indexes it.  When
+// language is set the database must be for that language.  Archives over
+// maxBytes, if positive, are rejected.
+func (s *StorageQLDB) Add(nwo common.NameWithOwner, r io.Reader, language string, maxBytes int64) (DBInfo, error) {
+	for _, name := range []string{nwo.Owner, nwo.Repo} {
+		if !ValidName(name) {
+			return DBInfo{}, invalid("%q is not a valid owner or repository name", name)
+		}
+	}
+
+	if err := os.MkdirAll(s.root, 0755); err != nil {
+		return DBInfo{}, err
+	}
+	// Stage next to the store so the final rename does not copy
+	tmp, err := os.CreateTemp(s.root, ".upload-*.zip")
+	if err != nil {
+		return DBInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	src := r
+	if maxBytes > 0 {
+		src = io.LimitReader(r, maxBytes+1)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return DBInfo{}, err
+	}
+	if n == 0 {
+		return DBInfo{}, invalid("empty archive")
+	}
+	if maxBytes > 0 && n > maxBytes {
+		return DBInfo{}, invalid("archive exceeds %d bytes", maxBytes)
+	}
+
+	md, err := validateArchive(tmp.Name())
+	if err != nil {
+		return DBInfo{}, err
+	}
+	if language != "" && !strings.EqualFold(md.PrimaryLanguage, language) {
+		return DBInfo{}, invalid("database is for %s, not %s", md.PrimaryLanguage, language)
+	}
+
+	rel := StorePath(nwo, md.PrimaryLanguage, md.CreationMetadata.SHA)
+	dst := filepath.Join(s.root, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return DBInfo{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return DBInfo{}, err
+	}
+	info, _, _ := s.refreshLocked(rel, nwo, hex.EncodeToString(hash.Sum(nil)))
+	if err := s.saveIndexLocked(); err != nil {
+		slog.Warn("Unable to save database index", "error", err)
+	}
+	slog.Info("Added database", "owner/repo", nwo, "path", rel, "language", info.Language,
+		"commit", info.SHA, "size", info.Size)
+	return info, nil
+}
+
+// FindChecksum returns the database whose archive has the given sha256.
+func (s *StorageQLDB) FindChecksum(sum string) (DBInfo, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, info := range s.index {
+		if info.Checksum != "" && info.Checksum == sum {
+			return info, true
+		}
+	}
+	return DBInfo{}, false
+}
+
+// validateArchive checks that the archive at zipPath holds a CodeQL
+// database: a codeql-database.yml naming its language and the db-{language}
+// directory next to it.
+func validateArchive(zipPath string) (databaseYml, error) {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return databaseYml{}, invalid("not a zip archive: %v", err)
+	}
+	defer zr.Close()
+
+	md, dir, err := archiveMetadata(&zr.Reader)
+	if err != nil {
+		return md, invalid("%v", err)
+	}
+	if md.PrimaryLanguage == "" {
+		return md, invalid("%s has no primaryLanguage", metadataFile)
+	}
+	if !namePattern.MatchString(md.PrimaryLanguage) {
+		return md, invalid("unsupported language %q", md.PrimaryLanguage)
+	}
+	if md.CreationMetadata.SHA != "" && !namePattern.MatchString(md.CreationMetadata.SHA) {
+		return md, invalid("invalid commit SHA %q", md.CreationMetadata.SHA)
+	}
+
+	dataset := path.Join(dir, "db-"+md.PrimaryLanguage) + "/"
+	for _, f := range zr.File {
+		if strings.HasPrefix(strings.TrimPrefix(f.Name, "./"), dataset) {
+			return md, nil
+		}
+	}
+	return md, invalid("archive has no %s directory", strings.TrimSuffix(dataset, "/"))
+}
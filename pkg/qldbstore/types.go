@@ -0,0 +1,63 @@
+package qldbstore
+
+import (
+	"sync"
+	"time"
+)
+
+type DBLocation struct {
+	Prefix string
+	File   string
+	// sha256 of the archive from the index, "" if it could not be read
+	Checksum string
+}
+
+// DBInfo is the index entry of a database archive.
+type DBInfo struct {
+	Owner string `json:"owner"`
+	Repo  string `json:"repo"`
+	// Path of the archive relative to the store root
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// sha256 of the archive, computed once when it is indexed
+	Checksum string `json:"checksum,omitempty"`
+
+	// From codeql-database.yml
+	Language     string    `json:"language,omitempty"`
+	SHA          string    `json:"sha,omitempty"`
+	CLIVersion   string    `json:"cli_version,omitempty"`
+	CreationTime time.Time `json:"creation_time"`
+	// Why the metadata could not be read, if it could not
+	Error string `json:"error,omitempty"`
+}
+
+// indexFile is the persisted form of the index.
+type indexFile struct {
+	Version   int      `json:"version"`
+	Databases []DBInfo `json:"databases"`
+}
+
+// databaseYml holds the fields of codeql-database.yml used by the index.
+type databaseYml struct {
+	PrimaryLanguage  string `yaml:"primaryLanguage"`
+	CreationMetadata struct {
+		SHA          string    `yaml:"sha"`
+		CLIVersion   string    `yaml:"cliVersion"`
+		CreationTime time.Time `yaml:"creationTime"`
+	} `yaml:"creationMetadata"`
+}
+
+type Visibles struct{}
+
+// StorageQLDB is a store of database archives in a local directory, with an
+// index of their metadata in {root}/index.json.  A repository may have
+// several databases, one per language and commit, under {root}/{owner}/{repo};
+// see StorePath.
+type StorageQLDB struct {
+	root string
+
+	mutex     sync.Mutex
+	index     map[string]DBInfo // by path relative to root
+	reindexed time.Time
+}
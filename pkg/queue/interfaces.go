@@ -10,5 +10,6 @@ type Queue interface {
 	Results() chan common.AnalyzeResult
 	StartAnalyses(analysis_repos *map[common.NameWithOwner]storage.DBLocation,
 		session_id int,
-		session_language string)
+		session_language string,
+		query_pack_hash string)
 }
@@ -3,7 +3,9 @@ package queue
 import (
 	"log/slog"
 	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/metrics"
 	"mrvacommander/pkg/storage"
+	"path/filepath"
 )
 
 func (q *QueueSingle) Jobs() chan common.AnalyzeJob {
@@ -15,17 +17,57 @@ func (q *QueueSingle) Results() chan common.AnalyzeResult {
 }
 
 func (q *QueueSingle) StartAnalyses(analysis_repos *map[common.NameWithOwner]storage.DBLocation, session_id int,
-	session_language string) {
+	session_language string, query_pack_hash string) {
 	slog.Debug("Queueing codeql database analyze jobs")
 
-	for nwo := range *analysis_repos {
+	for nwo, loc := range *analysis_repos {
+		// The checksum is recorded when the database is indexed
+		if loc.Checksum == "" {
+			slog.Warn("No database checksum, not using result cache", "owner/repo", nwo)
+		}
+
 		info := common.AnalyzeJob{
-			QueryPackId:   session_id,
-			QueryLanguage: session_language,
-			NWO:           nwo,
+			QueryPackId:      session_id,
+			QueryPackHash:    query_pack_hash,
+			QueryLanguage:    session_language,
+			NWO:              nwo,
+			DatabaseChecksum: loc.Checksum,
+			DatabasePath:     filepath.Join(loc.Prefix, loc.File),
+		}
+
+		if useCachedResult(query_pack_hash, loc.Checksum, session_id, nwo) {
+			storage.AddJob(session_id, info)
+			metrics.Repositories.WithLabelValues(session_language, metrics.RepoCached).Inc()
+			continue
 		}
+
+		metrics.Repositories.WithLabelValues(session_language, metrics.RepoQueued).Inc()
 		q.jobs <- info
 		storage.SetStatus(session_id, nwo, common.StatusQueued)
 		storage.AddJob(session_id, info)
 	}
 }
+
+// useCachedResult completes the job for nwo from the result cache if an
+// identical analysis has already been run.  It reports whether it did.
+func useCachedResult(query_pack_hash, db_checksum string, session_id int, nwo common.NameWithOwner) bool {
+	cr, ok := storage.FindCachedResult(query_pack_hash, db_checksum)
+	if !ok {
+		return false
+	}
+
+	if err := storage.LinkResultArchive(cr.ArchivePath, nwo, session_id); err != nil {
+		slog.Warn("Unable to reuse cached result archive", "owner/repo", nwo, "error", err)
+		return false
+	}
+
+	res := cr.Result
+	res.QueryPackId = session_id
+	res.NWO = nwo
+	res.CacheHit = true
+	storage.SetResult(session_id, nwo, res)
+	storage.SetStatus(session_id, nwo, common.StatusSuccess)
+
+	slog.Info("Using cached result", "owner/repo", nwo, "session", session_id)
+	return true
+}
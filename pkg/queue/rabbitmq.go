@@ -123,7 +123,7 @@ func (q *RabbitMQQueue) Results() chan common.AnalyzeResult {
 	return q.results
 }
 
-func (q *RabbitMQQueue) StartAnalyses(analysis_repos *map[common.NameWithOwner]storage.DBLocation, session_id int, session_language string) {
+func (q *RabbitMQQueue) StartAnalyses(analysis_repos *map[common.NameWithOwner]storage.DBLocation, session_id int, session_language string, query_pack_hash string) {
 	// TODO: Implement
 	log.Fatal("unimplemented")
 }
@@ -222,3 +222,14 @@ func (q *RabbitMQQueue) ConsumeResults(queueName string) {
 	}
 	close(q.results)
 }
+
+// CheckHealth reports whether the connection to RabbitMQ is still open.
+func (q *RabbitMQQueue) CheckHealth(ctx context.Context) error {
+	if q.conn == nil || q.conn.IsClosed() {
+		return fmt.Errorf("RabbitMQ connection closed")
+	}
+	if q.channel == nil || q.channel.IsClosed() {
+		return fmt.Errorf("RabbitMQ channel closed")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package results
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+
+	"mrvacommander/pkg/codeql"
+	"mrvacommander/pkg/common"
+)
+
+// ErrMixedColumns is returned when the #select tables to combine do not
+// all have the same columns, as happens for sessions running several
+// different queries.
+var ErrMixedColumns = errors.New("result tables have different columns")
+
+// CSVColumns returns the columns of the decoded #select result sets of the
+// archives, restricted to the query with the given BQRS path unless query is
+// empty.  It fails with ErrMixedColumns if the tables differ; archives
+// without results are skipped.
+func CSVColumns(archives []RepoArchive, query string) ([]string, error) {
+	var columns []string
+	var first string
+	for _, ar := range archives {
+		err := forEachSelectTable(ar.Path, query, func(name string, r *csv.Reader) error {
+			header, err := r.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if columns == nil {
+				columns, first = header, name
+				return nil
+			}
+			if !slices.Equal(columns, header) {
+				return fmt.Errorf("%w: %s has %s, %s has %s", ErrMixedColumns,
+					first, strings.Join(columns, ","), name, strings.Join(header, ","))
+			}
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+// CombineCSV writes the decoded #select result sets of every archive to w as
+// one CSV table, restricted to one query as in CSVColumns.  Each row is
+// prefixed with the repository and the query's BQRS path.  Nothing is
+// written if the tables do not share the same columns.
+func CombineCSV(w io.Writer, archives []RepoArchive, query string) error {
+	columns, err := CSVColumns(archives, query)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"repository", "query"}, columns...)); err != nil {
+		return err
+	}
+
+	for _, ar := range archives {
+		err := forEachSelectTable(ar.Path, query, func(name string, r *csv.Reader) error {
+			// Skip the header, checked above
+			if _, err := r.Read(); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			for {
+				row, err := r.Read()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := cw.Write(append([]string{nwoString(ar.NWO), name}, row...)); err != nil {
+					return err
+				}
+			}
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			slog.Debug("No result archive", "owner/repo", ar.NWO, "path", ar.Path)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to combine CSV for %s: %w", nwoString(ar.NWO), err)
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// forEachSelectTable calls fn with a reader over the decoded #select CSV of
+// every BQRS file in the archive, or only of the one named query if that is
+// not empty, in path order.
+func forEachSelectTable(archivePath, query string, fn func(query string, r *csv.Reader) error) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	entries := map[string]*zip.File{}
+	bqrs := []string{}
+	for _, f := range zr.File {
+		entries[f.Name] = f
+		if strings.HasSuffix(f.Name, ".bqrs") && (query == "" || f.Name == query) {
+			bqrs = append(bqrs, f.Name)
+		}
+	}
+	sort.Strings(bqrs)
+
+	for _, name := range bqrs {
+		f, ok := entries[codeql.DecodedFileName(name, codeql.SelectResultSetName, "csv")]
+		if !ok {
+			slog.Debug("No decoded CSV in result archive", "bqrs", name, "path", archivePath)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		r := csv.NewReader(rc)
+		r.FieldsPerRecord = -1
+		err = fn(name, r)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nwoString(nwo common.NameWithOwner) string {
+	return fmt.Sprintf("%s/%s", nwo.Owner, nwo.Repo)
+}
@@ -0,0 +1,141 @@
+package results
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Compare matches the findings of two sessions repository by repository.
+// Only repositories with an archive in both sessions are compared; the rest
+// are listed with a missing_in_base or missing_in_head status, and those
+// whose archives cannot be read with an error status.  With details
+// unset, only counts are reported.
+func Compare(baseID int, base []RepoArchive, headID int, head []RepoArchive, details bool) Comparison {
+	cmp := Comparison{
+		BaseSessionID: baseID,
+		HeadSessionID: headID,
+		Repositories:  []RepoComparison{},
+	}
+
+	baseByRepo := map[string]RepoArchive{}
+	for _, ar := range base {
+		baseByRepo[nwoString(ar.NWO)] = ar
+	}
+	headByRepo := map[string]RepoArchive{}
+	for _, ar := range head {
+		headByRepo[nwoString(ar.NWO)] = ar
+	}
+
+	for repo, bar := range baseByRepo {
+		har, ok := headByRepo[repo]
+		if !ok {
+			cmp.Repositories = append(cmp.Repositories,
+				RepoComparison{Repository: repo, Status: CompareStatusMissingInHead})
+			continue
+		}
+		rc, err := compareArchives(bar, har, details)
+		if err != nil {
+			slog.Warn("Failed to compare repository", "owner/repo", repo, "error", err)
+			cmp.Repositories = append(cmp.Repositories, rc)
+			continue
+		}
+		cmp.Added += rc.AddedCount
+		cmp.Removed += rc.RemovedCount
+		cmp.Unchanged += rc.UnchangedCount
+		cmp.Repositories = append(cmp.Repositories, rc)
+	}
+	for repo := range headByRepo {
+		if _, ok := baseByRepo[repo]; !ok {
+			cmp.Repositories = append(cmp.Repositories,
+				RepoComparison{Repository: repo, Status: CompareStatusMissingInBase})
+		}
+	}
+
+	sort.Slice(cmp.Repositories, func(i, j int) bool {
+		return cmp.Repositories[i].Repository < cmp.Repositories[j].Repository
+	})
+	return cmp
+}
+
+func compareArchives(base, head RepoArchive, details bool) (RepoComparison, error) {
+	rc := RepoComparison{Repository: nwoString(base.NWO), Status: CompareStatusCompared}
+
+	// The reported error leaves out details, which include server paths
+	baseFindings, err := LoadFindings(base)
+	if err != nil {
+		rc.Status, rc.Error = CompareStatusError, "base "+unreadableArchive
+		return rc, fmt.Errorf("failed to load base findings: %w", err)
+	}
+	headFindings, err := LoadFindings(head)
+	if err != nil {
+		rc.Status, rc.Error = CompareStatusError, "head "+unreadableArchive
+		return rc, fmt.Errorf("failed to load head findings: %w", err)
+	}
+
+	// Findings may repeat, so match them as multisets
+	remaining := map[string][]Finding{}
+	for _, f := range baseFindings {
+		k := matchKey(f)
+		remaining[k] = append(remaining[k], f)
+	}
+	for _, f := range headFindings {
+		k := matchKey(f)
+		if len(remaining[k]) > 0 {
+			remaining[k] = remaining[k][1:]
+			rc.UnchangedCount++
+			continue
+		}
+		rc.AddedCount++
+		if details {
+			rc.Added = append(rc.Added, f)
+		}
+	}
+
+	keys := make([]string, 0, len(remaining))
+	for k := range remaining {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		rc.RemovedCount += len(remaining[k])
+		if details {
+			rc.Removed = append(rc.Removed, remaining[k]...)
+		}
+	}
+	return rc, nil
+}
+
+// matchKey identifies a finding across sessions.  BQRS rows match on tuple
+// equality.  SARIF results match on their partial fingerprints when present,
+// which survive unrelated line shifts, and on their exact location otherwise.
+func matchKey(f Finding) string {
+	var sb strings.Builder
+	sb.WriteString(f.RuleID)
+	sb.WriteByte(0)
+
+	switch {
+	case f.Tuple != nil:
+		sb.WriteString("tuple")
+		for _, v := range f.Tuple {
+			sb.WriteByte(0)
+			sb.WriteString(v)
+		}
+	case len(f.Fingerprints) > 0:
+		names := make([]string, 0, len(f.Fingerprints))
+		for name := range f.Fingerprints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		sb.WriteString("fingerprints")
+		for _, name := range names {
+			fmt.Fprintf(&sb, "\x00%s=%s", name, f.Fingerprints[name])
+		}
+	default:
+		l := f.Location
+		fmt.Fprintf(&sb, "location\x00%s:%d:%d:%d:%d\x00%s",
+			l.Path, l.StartLine, l.StartColumn, l.EndLine, l.EndColumn, f.Message)
+	}
+	return sb.String()
+}
@@ -0,0 +1,129 @@
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"mrvacommander/utils"
+)
+
+// Severity reported for results that carry no SARIF level
+const (
+	defaultSarifLevel = "warning"
+	tupleSeverity     = "none"
+)
+
+// LoadFindings reads the findings of one result archive.  SARIF is preferred;
+// archives without SARIF fall back to the decoded #select rows of each BQRS
+// file, using the BQRS path as the rule id.  A missing archive is an error.
+func LoadFindings(ar RepoArchive) ([]Finding, error) {
+	if _, err := os.Stat(ar.Path); err != nil {
+		return nil, fmt.Errorf("no result archive for %s: %w", nwoString(ar.NWO), err)
+	}
+	buf, err := utils.ReadZipEntry(ar.Path, SarifEntryName)
+	if err == nil {
+		return sarifFindings(buf)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return tupleFindings(ar.Path)
+}
+
+func sarifFindings(buf []byte) ([]Finding, error) {
+	var log sarifResultLog
+	if err := json.Unmarshal(buf, &log); err != nil {
+		return nil, err
+	}
+
+	findings := []Finding{}
+	for _, run := range log.Runs {
+		rules := run.Tool.Driver.Rules
+		for _, res := range run.Results {
+			rule := lookupRule(rules, res)
+			f := Finding{
+				RuleID:       res.RuleID,
+				Severity:     res.Level,
+				Message:      res.Message.Text,
+				Fingerprints: res.PartialFingerprints,
+			}
+			if f.RuleID == "" && rule != nil {
+				f.RuleID = rule.ID
+			}
+			if f.Severity == "" && rule != nil {
+				f.Severity = rule.DefaultConfiguration.Level
+			}
+			if f.Severity == "" {
+				f.Severity = defaultSarifLevel
+			}
+			if len(res.Locations) > 0 {
+				pl := res.Locations[0].PhysicalLocation
+				f.Location = Location{
+					Path:        pl.ArtifactLocation.URI,
+					StartLine:   pl.Region.StartLine,
+					StartColumn: pl.Region.StartColumn,
+					EndLine:     pl.Region.EndLine,
+					EndColumn:   pl.Region.EndColumn,
+				}
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// lookupRule finds the rule metadata of a result by index or by id.
+func lookupRule(rules []sarifRule, res sarifResult) *sarifRule {
+	index := res.RuleIndex
+	id := res.RuleID
+	if res.Rule != nil {
+		if index == nil {
+			index = res.Rule.Index
+		}
+		if id == "" {
+			id = res.Rule.ID
+		}
+	}
+	if index != nil && *index >= 0 && *index < len(rules) {
+		return &rules[*index]
+	}
+	for i := range rules {
+		if rules[i].ID == id {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func tupleFindings(archivePath string) ([]Finding, error) {
+	findings := []Finding{}
+	err := forEachSelectTable(archivePath, "", func(query string, r *csv.Reader) error {
+		// Skip the column titles
+		if _, err := r.Read(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			findings = append(findings, Finding{
+				RuleID:   query,
+				Severity: tupleSeverity,
+				Tuple:    row,
+			})
+		}
+	})
+	return findings, err
+}
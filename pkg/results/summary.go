@@ -0,0 +1,90 @@
+package results
+
+import (
+	"log/slog"
+	"sort"
+)
+
+// Reported for repositories whose result archive cannot be read; details,
+// which include server paths, are only logged
+const unreadableArchive = "result archive could not be read"
+
+// Summarize aggregates the findings of all archives into counts per rule,
+// per severity and per repository, with a few sample locations per rule.
+func Summarize(sessionID int, archives []RepoArchive, opts SummaryOptions) Summary {
+	sum := Summary{
+		SessionID:       sessionID,
+		Severities:      map[string]int{},
+		Rules:           []RuleSummary{},
+		TopRepositories: []RepoSummary{},
+
+		FailedRepositories: []RepoError{},
+	}
+
+	rules := map[string]*RuleSummary{}
+	for _, ar := range archives {
+		findings, err := LoadFindings(ar)
+		if err != nil {
+			// One unreadable archive should not hide the rest of the session
+			slog.Warn("Skipping repository in summary", "owner/repo", ar.NWO, "error", err)
+			sum.FailedRepositories = append(sum.FailedRepositories,
+				RepoError{Repository: nwoString(ar.NWO), Error: unreadableArchive})
+			continue
+		}
+		slog.Debug("Loaded findings", "owner/repo", ar.NWO, "count", len(findings))
+
+		sum.RepositoryCount++
+		sum.TotalCount += len(findings)
+		if len(findings) > 0 {
+			sum.TopRepositories = append(sum.TopRepositories,
+				RepoSummary{Repository: nwoString(ar.NWO), Count: len(findings)})
+		}
+
+		seen := map[string]bool{}
+		for _, f := range findings {
+			sum.Severities[f.Severity]++
+
+			rs, ok := rules[f.RuleID]
+			if !ok {
+				rs = &RuleSummary{RuleID: f.RuleID, Samples: []SampleLocation{}}
+				rules[f.RuleID] = rs
+			}
+			rs.Count++
+			if !seen[f.RuleID] {
+				seen[f.RuleID] = true
+				rs.RepositoryCount++
+				// Take samples from different repositories first
+				if len(rs.Samples) < opts.SamplesPerRule {
+					rs.Samples = append(rs.Samples, SampleLocation{
+						Repository: nwoString(ar.NWO),
+						Message:    f.Message,
+						Location:   f.Location,
+						Tuple:      f.Tuple,
+					})
+				}
+			}
+		}
+	}
+
+	for _, rs := range rules {
+		sum.Rules = append(sum.Rules, *rs)
+	}
+	sort.Slice(sum.Rules, func(i, j int) bool {
+		if sum.Rules[i].Count != sum.Rules[j].Count {
+			return sum.Rules[i].Count > sum.Rules[j].Count
+		}
+		return sum.Rules[i].RuleID < sum.Rules[j].RuleID
+	})
+
+	sort.Slice(sum.TopRepositories, func(i, j int) bool {
+		if sum.TopRepositories[i].Count != sum.TopRepositories[j].Count {
+			return sum.TopRepositories[i].Count > sum.TopRepositories[j].Count
+		}
+		return sum.TopRepositories[i].Repository < sum.TopRepositories[j].Repository
+	})
+	if len(sum.TopRepositories) > opts.TopRepositories {
+		sum.TopRepositories = sum.TopRepositories[:opts.TopRepositories]
+	}
+
+	return sum
+}
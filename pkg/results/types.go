@@ -0,0 +1,166 @@
+package results
+
+import (
+	"encoding/json"
+
+	"mrvacommander/pkg/common"
+)
+
+// RepoArchive identifies the stored result archive of one repository in a
+// variant analysis session.
+type RepoArchive struct {
+	NWO  common.NameWithOwner
+	Path string
+}
+
+// sarifRun is a SARIF run kept in raw form so that merging does not drop
+// properties we do not model.
+type sarifRun map[string]json.RawMessage
+
+type sarifLog struct {
+	Runs []sarifRun `json:"runs"`
+}
+
+type versionControlDetails struct {
+	RepositoryURI string `json:"repositoryUri"`
+	RevisionID    string `json:"revisionId,omitempty"`
+}
+
+// Finding is one result of one repository, read either from SARIF or, for
+// queries without alert output, from a decoded BQRS #select row.
+type Finding struct {
+	RuleID       string            `json:"rule_id"`
+	Severity     string            `json:"severity"`
+	Message      string            `json:"message,omitempty"`
+	Location     Location          `json:"location"`
+	Fingerprints map[string]string `json:"fingerprints,omitempty"`
+	Tuple        []string          `json:"tuple,omitempty"`
+}
+
+type Location struct {
+	Path        string `json:"path,omitempty"`
+	StartLine   int    `json:"start_line,omitempty"`
+	StartColumn int    `json:"start_column,omitempty"`
+	EndLine     int    `json:"end_line,omitempty"`
+	EndColumn   int    `json:"end_column,omitempty"`
+}
+
+// Summary aggregates the findings of all succeeded repositories of a
+// session.
+type Summary struct {
+	SessionID       int            `json:"session_id"`
+	RepositoryCount int            `json:"repository_count"`
+	TotalCount      int            `json:"total_count"`
+	Severities      map[string]int `json:"severities"`
+	Rules           []RuleSummary  `json:"rules"`
+	TopRepositories []RepoSummary  `json:"top_repositories"`
+	// Repositories whose results could not be read and are left out of
+	// the counts
+	FailedRepositories []RepoError `json:"failed_repositories"`
+}
+
+type RepoError struct {
+	Repository string `json:"repository"`
+	Error      string `json:"error"`
+}
+
+type RuleSummary struct {
+	RuleID          string           `json:"rule_id"`
+	Count           int              `json:"count"`
+	RepositoryCount int              `json:"repository_count"`
+	Samples         []SampleLocation `json:"samples"`
+}
+
+type RepoSummary struct {
+	Repository string `json:"repository"`
+	Count      int    `json:"count"`
+}
+
+type SampleLocation struct {
+	Repository string   `json:"repository"`
+	Message    string   `json:"message,omitempty"`
+	Location   Location `json:"location"`
+	Tuple      []string `json:"tuple,omitempty"`
+}
+
+// SummaryOptions bound the size of a Summary.
+type SummaryOptions struct {
+	TopRepositories int
+	SamplesPerRule  int
+}
+
+type sarifResultLog struct {
+	Runs []struct {
+		Tool struct {
+			Driver struct {
+				Rules []sarifRule `json:"rules"`
+			} `json:"driver"`
+		} `json:"tool"`
+		Results []sarifResult `json:"results"`
+	} `json:"runs"`
+}
+
+type sarifRule struct {
+	ID                   string `json:"id"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifResult struct {
+	RuleID    string `json:"ruleId"`
+	RuleIndex *int   `json:"ruleIndex"`
+	Rule      *struct {
+		ID    string `json:"id"`
+		Index *int   `json:"index"`
+	} `json:"rule"`
+	Level   string `json:"level"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Locations []struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region struct {
+				StartLine   int `json:"startLine"`
+				StartColumn int `json:"startColumn"`
+				EndLine     int `json:"endLine"`
+				EndColumn   int `json:"endColumn"`
+			} `json:"region"`
+		} `json:"physicalLocation"`
+	} `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+// Comparison reports how the findings of a head session differ from those
+// of a base session, per repository analyzed successfully in either.
+type Comparison struct {
+	BaseSessionID int              `json:"base_session_id"`
+	HeadSessionID int              `json:"head_session_id"`
+	Added         int              `json:"added"`
+	Removed       int              `json:"removed"`
+	Unchanged     int              `json:"unchanged"`
+	Repositories  []RepoComparison `json:"repositories"`
+}
+
+// Repository comparison states
+const (
+	CompareStatusCompared      = "compared"
+	CompareStatusMissingInBase = "missing_in_base"
+	CompareStatusMissingInHead = "missing_in_head"
+	CompareStatusError         = "error"
+)
+
+type RepoComparison struct {
+	Repository     string    `json:"repository"`
+	Status         string    `json:"status"`
+	Added          []Finding `json:"added,omitempty"`
+	Removed        []Finding `json:"removed,omitempty"`
+	AddedCount     int       `json:"added_count"`
+	RemovedCount   int       `json:"removed_count"`
+	UnchangedCount int       `json:"unchanged_count"`
+	// Set with CompareStatusError
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"mrvacommander/pkg/common"
+)
+
+var (
+	ErrInvalidArtifactToken = errors.New("invalid artifact token")
+	ErrExpiredArtifactToken = errors.New("expired artifact token")
+)
+
+const defaultArtifactTTL = time.Hour
+
+// ArtifactSigner issues and checks the opaque tokens in artifact download
+// URLs.  A token names one repository's result archive in one session and
+// carries an expiry, both covered by an HMAC, so clients can neither forge
+// tokens nor use them to reach other files.
+type ArtifactSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+type artifactClaims struct {
+	Session int    `json:"s"`
+	Owner   string `json:"o"`
+	Repo    string `json:"r"`
+	Expiry  int64  `json:"e"`
+}
+
+// NewArtifactSigner returns a signer using secret, or a random secret if it
+// is empty.  A ttl of zero selects the default of one hour.
+func NewArtifactSigner(secret []byte, ttl time.Duration) *ArtifactSigner {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	}
+	if ttl <= 0 {
+		ttl = defaultArtifactTTL
+	}
+	return &ArtifactSigner{secret: secret, ttl: ttl}
+}
+
+// artifactSecret picks the signing secret from the configuration or the
+// environment.
+func artifactSecret(configured string) []byte {
+	if configured != "" {
+		return []byte(configured)
+	}
+	if env := os.Getenv("MRVA_ARTIFACT_SECRET"); env != "" {
+		return []byte(env)
+	}
+	slog.Warn("No artifact secret configured, download links will not survive a restart")
+	return nil
+}
+
+// Sign returns a token for the result archive of js.
+func (s *ArtifactSigner) Sign(js common.JobSpec) string {
+	claims := artifactClaims{
+		Session: js.JobID,
+		Owner:   js.Owner,
+		Repo:    js.Repo,
+		Expiry:  time.Now().Add(s.ttl).Unix(),
+	}
+	// Marshalling a struct of strings and integers cannot fail
+	payload, _ := json.Marshal(claims)
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(s.mac(payload))
+}
+
+// Verify checks a token and returns the job whose archive it names.
+func (s *ArtifactSigner) Verify(token string) (common.JobSpec, error) {
+	enc := base64.RawURLEncoding
+
+	encPayload, encMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return common.JobSpec{}, ErrInvalidArtifactToken
+	}
+	payload, err := enc.DecodeString(encPayload)
+	if err != nil {
+		return common.JobSpec{}, ErrInvalidArtifactToken
+	}
+	mac, err := enc.DecodeString(encMAC)
+	if err != nil {
+		return common.JobSpec{}, ErrInvalidArtifactToken
+	}
+	if !hmac.Equal(mac, s.mac(payload)) {
+		return common.JobSpec{}, ErrInvalidArtifactToken
+	}
+
+	var claims artifactClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return common.JobSpec{}, ErrInvalidArtifactToken
+	}
+	if time.Now().Unix() > claims.Expiry {
+		return common.JobSpec{}, ErrExpiredArtifactToken
+	}
+
+	return common.JobSpec{
+		JobID:         claims.Session,
+		NameWithOwner: common.NameWithOwner{Owner: claims.Owner, Repo: claims.Repo},
+	}, nil
+}
+
+func (s *ArtifactSigner) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
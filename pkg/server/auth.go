@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/storage"
+)
+
+// Authenticate resolves the bearer token of each request to an identity
+// stored in the request context.  Artifact downloads are exempt because
+// their signed URL is the credential, and so are the probes of
+// unauthenticatedPaths.
+func (c *CommanderSingle) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.vis.Auth == nil || strings.HasPrefix(r.URL.Path, "/download-server/") ||
+			unauthenticatedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := bearerToken(r)
+		var id auth.Identity
+		if err == nil {
+			id, err = c.vis.Auth.Authenticate(token)
+		}
+		if err != nil {
+			slog.Info("Rejected request", "path", r.URL.Path, "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mrvacommander"`)
+			msg := "Bad credentials"
+			if errors.Is(err, auth.ErrNoToken) {
+				msg = "Requires authentication"
+			}
+			writeError(w, http.StatusUnauthorized, msg)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	})
+}
+
+// Health and version probes and metrics, which reveal nothing about
+// individual sessions
+var unauthenticatedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/version": true,
+	"/metrics": true,
+}
+
+// bearerToken extracts the token of an Authorization header using either
+// the Bearer scheme or the token scheme sent by the gh CLI.
+func bearerToken(r *http.Request) (string, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || token == "" {
+		return "", auth.ErrNoToken
+	}
+	if !strings.EqualFold(scheme, "bearer") && !strings.EqualFold(scheme, "token") {
+		return "", auth.ErrNoToken
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// authorizeSession checks that a session exists and that the requester is
+// its actor or an admin, replying with an error if not.
+func authorizeSession(w http.ResponseWriter, r *http.Request, vaid int) bool {
+	sn, ok := storage.GetSession(vaid)
+	if !ok {
+		slog.Error("No session found for given id", "id", vaid)
+		writeError(w, http.StatusNotFound, "")
+		return false
+	}
+	id := auth.FromContext(r.Context())
+	if !id.CanAccess(sn.Actor) {
+		slog.Warn("Session access denied", "id", vaid, "actor", id.Login, "owner", sn.Actor.Login)
+		writeError(w, http.StatusForbidden, "Session belongs to another actor")
+		return false
+	}
+	return true
+}
+
+// requireAdmin replies with an error unless the requester is an admin.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !auth.FromContext(r.Context()).Admin {
+		writeError(w, http.StatusForbidden, "Admin access required")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/metrics"
+	"mrvacommander/pkg/storage"
+)
+
+// consumeResults records the analysis results reported by agents until the
+// results channel is closed.
+func (c *CommanderSingle) consumeResults() {
+	for res := range c.vis.Queue.Results() {
+		c.recordResult(res)
+	}
+	slog.Info("Results channel closed")
+}
+
+func (c *CommanderSingle) recordResult(res common.AnalyzeResult) {
+	slog.Debug("Recording analysis result", "session", res.QueryPackId, "owner/repo", res.NWO,
+		"status", res.Status.ToExternalString())
+
+	// Store the archive before the status, so a successful job can always
+	// be downloaded
+	archive := res.ResultArchive
+	res.ResultArchive = nil
+	zpath := ""
+	if res.Status == common.StatusSuccess {
+		var err error
+		zpath, err = saveResultArchive(res.NWO, res.QueryPackId, archive)
+		if err != nil {
+			slog.Error("Failed to store result archive", "session", res.QueryPackId,
+				"owner/repo", res.NWO, "error", err)
+			res.Status = common.StatusError
+		}
+	}
+
+	storage.SetResult(res.QueryPackId, res.NWO, res)
+	storage.SetStatus(res.QueryPackId, res.NWO, res.Status)
+
+	// The key carries the CLI version of the agent that ran the analysis
+	if res.CacheKey.CLIVersion != "" {
+		storage.SetCLIVersion(res.CacheKey.CLIVersion)
+	}
+
+	c.publishResult(res)
+	c.notifyResult(res)
+
+	sn, _ := storage.GetSession(res.QueryPackId)
+	if res.Status != common.StatusSuccess {
+		metrics.Repositories.WithLabelValues(sn.Language, metrics.RepoFailed).Inc()
+		return
+	}
+	metrics.Repositories.WithLabelValues(sn.Language, metrics.RepoSucceeded).Inc()
+	metrics.ResultArchiveBytes.Observe(float64(len(archive)))
+
+	// Only results whose archive is stored can be reused
+	if res.CacheKey.Complete() {
+		storage.SetCachedResult(res.CacheKey, storage.CachedResult{
+			Result:      res,
+			ArchivePath: zpath,
+			CreatedAt:   time.Now(),
+		})
+	}
+}
+
+// expireLoop fails the analyses that have been pending for longer than the
+// job timeout, so their sessions complete and they can be retried.
+func (c *CommanderSingle) expireLoop() {
+	interval := min(c.jobTimeout/4, time.Minute)
+	for {
+		time.Sleep(interval)
+		c.expireJobs(time.Now())
+	}
+}
+
+func (c *CommanderSingle) expireJobs(now time.Time) {
+	for _, js := range storage.StalledJobs(now.Add(-c.jobTimeout)) {
+		slog.Warn("Analysis timed out", "session", js.JobID, "owner/repo", js.NameWithOwner,
+			"timeout", c.jobTimeout)
+		c.recordResult(common.AnalyzeResult{
+			Status:      common.StatusError,
+			QueryPackId: js.JobID,
+			NWO:         js.NameWithOwner,
+		})
+	}
+}
+
+// saveResultArchive stores the archive of a successful result and returns
+// its path.
+func saveResultArchive(nwo common.NameWithOwner, vaid int, archive []byte) (string, error) {
+	if len(archive) == 0 {
+		return "", errors.New("agent sent no result archive")
+	}
+	return storage.SaveResultArchive(nwo, vaid, archive)
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/qldbstore"
+)
+
+// defaultMaxDatabaseBytes bounds database uploads when not configured.
+const defaultMaxDatabaseBytes = 4 << 30
+
+// Add a database archive, sent as the request body, to the store.  The
+// optional language parameter must match the database's language.
+func (c *CommanderSingle) AdminUploadDatabase(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	nwo := common.NameWithOwner{Owner: vars["owner"], Repo: vars["repo"]}
+
+	if r.Body == nil || r.ContentLength == 0 {
+		writeError(w, http.StatusBadRequest, "Requires a request body")
+		return
+	}
+	maxBytes := c.maxDB
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxDatabaseBytes
+	}
+	if r.ContentLength > maxBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "Database archive is too large")
+		return
+	}
+
+	info, err := c.vis.QLDBStore.Add(nwo, r.Body, r.URL.Query().Get("language"), maxBytes)
+	if errors.Is(err, qldbstore.ErrInvalidDatabase) {
+		slog.Info("Rejected database upload", "owner/repo", nwo, "error", err)
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed", APIErrorDetail{
+			Resource: "Database", Code: ErrCodeInvalid, Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		slog.Error("Unable to store database", "owner/repo", nwo, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to store database")
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+type DatabaseList struct {
+	TotalCount int                `json:"total_count"`
+	Databases  []qldbstore.DBInfo `json:"databases"`
+}
+
+// RepositoryListFile is a repository list in the databases.json format of
+// the CodeQL extension for VS Code; its repositories can be submitted as is.
+type RepositoryListFile struct {
+	Version   int `json:"version"`
+	Databases struct {
+		VariantAnalysis struct {
+			RepositoryLists []RepositoryList `json:"repositoryLists"`
+			Owners          []string         `json:"owners"`
+			Repositories    []string         `json:"repositories"`
+		} `json:"variantAnalysis"`
+		Local struct {
+			Lists     []interface{} `json:"lists"`
+			Databases []interface{} `json:"databases"`
+		} `json:"local"`
+	} `json:"databases"`
+	Selected struct {
+		Kind     string `json:"kind"`
+		ListName string `json:"listName"`
+	} `json:"selected"`
+}
+
+type RepositoryList struct {
+	Name         string   `json:"name"`
+	Repositories []string `json:"repositories"`
+}
+
+// databaseFilter holds the query parameters of a database listing.
+type databaseFilter struct {
+	owner      string
+	repo       string
+	language   string
+	cliVersion string
+	checksum   string
+	after      time.Time
+	before     time.Time
+	minSize    int64
+	maxSize    int64
+}
+
+func parseDatabaseFilter(q url.Values) (databaseFilter, []APIErrorDetail) {
+	f := databaseFilter{
+		owner:      q.Get("owner"),
+		repo:       q.Get("repo"),
+		language:   q.Get("language"),
+		cliVersion: q.Get("cli_version"),
+		checksum:   q.Get("checksum"),
+	}
+	var errs []APIErrorDetail
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+		end  bool
+	}{{"created_after", &f.after, false}, {"created_before", &f.before, true}} {
+		val := q.Get(p.name)
+		if val == "" {
+			continue
+		}
+		t, err := parseTimeParam(val, p.end)
+		if err != nil {
+			errs = append(errs, APIErrorDetail{Resource: "Database", Field: p.name, Code: ErrCodeInvalid,
+				Message: "expected an RFC 3339 timestamp or a YYYY-MM-DD date"})
+			continue
+		}
+		*p.t = t
+	}
+	for _, p := range []struct {
+		name string
+		n    *int64
+	}{{"min_size", &f.minSize}, {"max_size", &f.maxSize}} {
+		val := q.Get(p.name)
+		if val == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || n < 0 {
+			errs = append(errs, APIErrorDetail{Resource: "Database", Field: p.name, Code: ErrCodeInvalid,
+				Message: "expected a size in bytes"})
+			continue
+		}
+		*p.n = n
+	}
+	return f, errs
+}
+
+func (f databaseFilter) match(info qldbstore.DBInfo) bool {
+	if f.owner != "" && !strings.EqualFold(info.Owner, f.owner) {
+		return false
+	}
+	if f.repo != "" && !strings.EqualFold(info.Repo, f.repo) {
+		return false
+	}
+	if f.language != "" && !strings.EqualFold(info.Language, f.language) {
+		return false
+	}
+	// A version prefix such as 2.17 matches all its releases
+	if f.cliVersion != "" && info.CLIVersion != f.cliVersion && !strings.HasPrefix(info.CLIVersion, f.cliVersion+".") {
+		return false
+	}
+	if f.checksum != "" && !strings.EqualFold(info.Checksum, f.checksum) {
+		return false
+	}
+	if !f.after.IsZero() && info.Created().Before(f.after) {
+		return false
+	}
+	if !f.before.IsZero() && !info.Created().Before(f.before) {
+		return false
+	}
+	if f.minSize > 0 && info.Size < f.minSize {
+		return false
+	}
+	if f.maxSize > 0 && info.Size > f.maxSize {
+		return false
+	}
+	return true
+}
+
+// listDatabases returns the databases matching the query of r that the
+// requester may analyze, replying with an error if the query is invalid.
+func (c *CommanderSingle) listDatabases(w http.ResponseWriter, r *http.Request, errs []APIErrorDetail) ([]qldbstore.DBInfo, bool) {
+	f, ferrs := parseDatabaseFilter(r.URL.Query())
+	errs = append(errs, ferrs...)
+	if len(errs) > 0 {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed", errs...)
+		return nil, false
+	}
+	id := auth.FromContext(r.Context())
+	dbs := []qldbstore.DBInfo{}
+	for _, info := range c.vis.QLDBStore.List() {
+		if f.match(info) && c.policy.Allows(id, common.NameWithOwner{Owner: info.Owner, Repo: info.Repo}) {
+			dbs = append(dbs, info)
+		}
+	}
+	return dbs, true
+}
+
+// List the databases in the store, by repository and newest first, filtered
+// by the query parameters owner, repo, language, cli_version, checksum,
+// created_after, created_before, min_size and max_size, and paginated with
+// page and per_page.  Only databases the requester may analyze are listed.
+func (c *CommanderSingle) MRVAListDatabases(w http.ResponseWriter, r *http.Request) {
+	page, perPage, errs := pageParams(r, "Database")
+	dbs, ok := c.listDatabases(w, r, errs)
+	if !ok {
+		return
+	}
+
+	list := DatabaseList{TotalCount: len(dbs), Databases: []qldbstore.DBInfo{}}
+	first := (page - 1) * perPage
+	for i := first; i < len(dbs) && i < first+perPage; i++ {
+		list.Databases = append(list.Databases, dbs[i])
+	}
+
+	lastPage := (len(dbs) + perPage - 1) / perPage
+	if link := paginationLinks(c.baseURL()+r.URL.Path, r.URL.Query(), page, lastPage); link != "" {
+		w.Header().Set("Link", link)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// Export the repositories with databases matching the same filters as
+// MRVAListDatabases as a repository list file.  The list is called after the
+// name parameter.
+func (c *CommanderSingle) MRVAExportDatabases(w http.ResponseWriter, r *http.Request) {
+	dbs, ok := c.listDatabases(w, r, nil)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "mrva-databases"
+	}
+
+	list := RepositoryList{Name: name, Repositories: []string{}}
+	seen := map[string]bool{}
+	for _, info := range dbs {
+		nwo := info.Owner + "/" + info.Repo
+		if !seen[nwo] {
+			seen[nwo] = true
+			list.Repositories = append(list.Repositories, nwo)
+		}
+	}
+
+	var file RepositoryListFile
+	file.Version = 1
+	file.Databases.VariantAnalysis.RepositoryLists = []RepositoryList{list}
+	file.Databases.VariantAnalysis.Owners = []string{}
+	file.Databases.VariantAnalysis.Repositories = []string{}
+	file.Databases.Local.Lists = []interface{}{}
+	file.Databases.Local.Databases = []interface{}{}
+	file.Selected.Kind = "variantAnalysisUserDefinedList"
+	file.Selected.ListName = name
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "databases.json"))
+	writeJSON(w, http.StatusOK, file)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Documentation linked from error responses
+const documentationURL = "https://docs.github.com/rest/code-scanning/code-scanning"
+
+// APIError is an error response in the format of the GitHub REST API, which
+// the gh CLI and the VS Code extension know how to display.
+type APIError struct {
+	Status           int              `json:"-"`
+	Message          string           `json:"message"`
+	DocumentationURL string           `json:"documentation_url"`
+	Errors           []APIErrorDetail `json:"errors,omitempty"`
+}
+
+// APIErrorDetail describes one invalid field of a request.  Code is one of
+// the GitHub codes missing, missing_field, invalid, already_exists,
+// unprocessable or custom.
+type APIErrorDetail struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field,omitempty"`
+	Code     string `json:"code"`
+	Message  string `json:"message,omitempty"`
+}
+
+// Codes of APIErrorDetail
+const (
+	ErrCodeMissing       = "missing"
+	ErrCodeMissingField  = "missing_field"
+	ErrCodeInvalid       = "invalid"
+	ErrCodeAlreadyExists = "already_exists"
+	ErrCodeUnprocessable = "unprocessable"
+	ErrCodeCustom        = "custom"
+)
+
+func NewAPIError(status int, msg string, details ...APIErrorDetail) *APIError {
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	return &APIError{
+		Status:           status,
+		Message:          msg,
+		DocumentationURL: documentationURL,
+		Errors:           details,
+	}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Write sends the error as the response.
+func (e *APIError) Write(w http.ResponseWriter) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Error encoding error response as JSON", "error", err)
+		http.Error(w, e.Message, e.Status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	w.Write(buf)
+}
+
+// writeError replies with an APIError.  An empty msg uses the status text.
+func writeError(w http.ResponseWriter, status int, msg string, details ...APIErrorDetail) {
+	NewAPIError(status, msg, details...).Write(w)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+)
+
+const (
+	eventBufferSize   = 64
+	eventKeepalive    = 15 * time.Second
+	eventRepository   = "repository"
+	eventSessionState = "session"
+)
+
+// RepoEvent is sent when the analysis of a repository changes status.
+type RepoEvent struct {
+	SessionID      int    `json:"session_id"`
+	Repository     string `json:"repository"`
+	AnalysisStatus string `json:"analysis_status"`
+	ResultCount    int    `json:"result_count"`
+	CacheHit       bool   `json:"cache_hit,omitempty"`
+}
+
+// SessionEvent is sent when a session reaches a final state; it is the last
+// event of a stream.
+type SessionEvent struct {
+	SessionID int    `json:"session_id"`
+	Status    string `json:"status"`
+}
+
+type sessionEvent struct {
+	name string
+	data interface{}
+}
+
+// eventBroker fans out session events to the streams subscribed to them.
+type eventBroker struct {
+	mutex sync.Mutex
+	subs  map[int]map[chan sessionEvent]struct{}
+
+	// Closed when the server shuts down, which ends all streams
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subs: make(map[int]map[chan sessionEvent]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// shutdown ends all streams, which would otherwise hold up a graceful
+// shutdown of the server until its timeout.
+func (b *eventBroker) shutdown() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+func (b *eventBroker) subscribe(vaid int) chan sessionEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ch := make(chan sessionEvent, eventBufferSize)
+	if b.subs[vaid] == nil {
+		b.subs[vaid] = make(map[chan sessionEvent]struct{})
+	}
+	b.subs[vaid][ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes ch and closes it, unless publish already did.
+func (b *eventBroker) unsubscribe(vaid int, ch chan sessionEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.subs[vaid][ch]; ok {
+		delete(b.subs[vaid], ch)
+		close(ch)
+	}
+	if len(b.subs[vaid]) == 0 {
+		delete(b.subs, vaid)
+	}
+}
+
+// publish sends ev to all subscribers of the session without blocking.  A
+// subscriber too slow to keep up is dropped; its stream ends and the client
+// reconnects to a fresh snapshot.
+func (b *eventBroker) publish(vaid int, ev sessionEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for ch := range b.subs[vaid] {
+		select {
+		case ch <- ev:
+		default:
+			slog.Warn("Dropping slow event subscriber", "session", vaid)
+			delete(b.subs[vaid], ch)
+			close(ch)
+		}
+	}
+}
+
+// publishResult announces the new status of a repository and, if it was the
+// last one pending, the end of the session.
+func (c *CommanderSingle) publishResult(res common.AnalyzeResult) {
+	c.events.publish(res.QueryPackId, sessionEvent{eventRepository, repoEvent(res.QueryPackId, res.NWO)})
+	if storage.IsSessionComplete(res.QueryPackId) {
+		c.events.publish(res.QueryPackId, sessionEvent{eventSessionState, SessionEvent{
+			SessionID: res.QueryPackId,
+			Status:    sessionStatus(res.QueryPackId),
+		}})
+	}
+}
+
+func repoEvent(vaid int, nwo common.NameWithOwner) RepoEvent {
+	ar := storage.GetResult(common.JobSpec{JobID: vaid, NameWithOwner: nwo})
+	return RepoEvent{
+		SessionID:      vaid,
+		Repository:     fmt.Sprintf("%s/%s", nwo.Owner, nwo.Repo),
+		AnalysisStatus: storage.GetStatus(vaid, nwo).ToExternalString(),
+		ResultCount:    ar.ResultCount,
+		CacheHit:       ar.CacheHit,
+	}
+}
+
+// sessionStatus summarizes the status of a session in the terms of the
+// GitHub API: in_progress until every repository is done, then succeeded
+// if all of them were, failed otherwise.
+func sessionStatus(vaid int) string {
+	if !storage.IsSessionComplete(vaid) {
+		return "in_progress"
+	}
+	for _, job := range storage.GetJobList(vaid) {
+		if storage.GetStatus(vaid, job.NWO) != common.StatusSuccess {
+			return "failed"
+		}
+	}
+	return "succeeded"
+}
+
+// Stream the progress of a session as Server-Sent Events: first the current
+// status of every repository, then each change as agents report results,
+// and finally a session event when all repositories are done.
+func (c *CommanderSingle) MRVAEvents(w http.ResponseWriter, r *http.Request) {
+	vaid, ok := sessionID(w, r, "codeql_variant_analysis_id")
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+	slog.Info("MRVA event stream", "session", vaid)
+
+	// Subscribe before taking the snapshot so no transition is missed; a
+	// repository may then be reported twice, which is harmless.
+	ch := c.events.subscribe(vaid)
+	defer c.events.unsubscribe(vaid, ch)
+
+	// Streams outlive the configured write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, job := range storage.GetJobList(vaid) {
+		writeEvent(w, eventRepository, repoEvent(vaid, job.NWO))
+	}
+	if storage.IsSessionComplete(vaid) {
+		writeEvent(w, eventSessionState, SessionEvent{SessionID: vaid, Status: sessionStatus(vaid)})
+		flusher.Flush()
+		return
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			writeEvent(w, ev.name, ev.data)
+			flusher.Flush()
+			if ev.name == eventSessionState {
+				return
+			}
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-c.events.done:
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, name string, data interface{}) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Error encoding event as JSON", "error", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, buf)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+	"mrvacommander/utils"
+)
+
+const readinessTimeout = 5 * time.Second
+
+type componentHealth struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type readiness struct {
+	Status string                     `json:"status"`
+	Checks map[string]componentHealth `json:"checks"`
+}
+
+type versionInfo struct {
+	utils.BuildInfo
+	// Version of the CodeQL CLI last reported by an agent
+	CodeQLCLIVersion string `json:"codeql_cli_version,omitempty"`
+}
+
+// Liveness: the process is up and serving requests
+func (c *CommanderSingle) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness: the modules the API depends on are usable
+func (c *CommanderSingle) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	rd := readiness{Status: "ok", Checks: map[string]componentHealth{}}
+	modules := map[string]interface{}{
+		"queue":            c.vis.Queue,
+		"server_store":     c.vis.ServerStore,
+		"query_pack_store": c.vis.QueryPackStore,
+		"ql_db_store":      c.vis.QLDBStore,
+	}
+	for name, m := range modules {
+		ch := checkModule(ctx, m)
+		if ch.Status != "ok" {
+			rd.Status = "unavailable"
+		}
+		rd.Checks[name] = ch
+	}
+
+	status := http.StatusOK
+	if rd.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, rd)
+}
+
+func checkModule(ctx context.Context, m interface{}) componentHealth {
+	if m == nil {
+		return componentHealth{Status: "unavailable", Detail: "not configured"}
+	}
+	hc, ok := m.(common.HealthChecker)
+	if !ok {
+		// In-process modules have nothing to check
+		return componentHealth{Status: "ok", Detail: "in-process"}
+	}
+	if err := hc.CheckHealth(ctx); err != nil {
+		return componentHealth{Status: "unavailable", Detail: err.Error()}
+	}
+	return componentHealth{Status: "ok"}
+}
+
+// Build information of the server
+func (c *CommanderSingle) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, versionInfo{
+		BuildInfo:        utils.GetBuildInfo(),
+		CodeQLCLIVersion: storage.GetCLIVersion(),
+	})
+}
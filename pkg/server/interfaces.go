@@ -5,10 +5,29 @@ import "net/http"
 type Commander interface{}
 
 type CommanderAPI interface {
+	Authenticate(next http.Handler) http.Handler
 	MRVARequestID(w http.ResponseWriter, r *http.Request)
 	MRVARequest(w http.ResponseWriter, r *http.Request)
 	RootHandler(w http.ResponseWriter, r *http.Request)
 	MRVAStatus(w http.ResponseWriter, r *http.Request)
+	MRVAListSessions(w http.ResponseWriter, r *http.Request)
+	MRVADeleteSession(w http.ResponseWriter, r *http.Request)
+	MRVARerun(w http.ResponseWriter, r *http.Request)
 	MRVADownloadArtifact(w http.ResponseWriter, r *http.Request)
+	MRVADownloadSarif(w http.ResponseWriter, r *http.Request)
+	MRVADownloadCSV(w http.ResponseWriter, r *http.Request)
+	MRVASummary(w http.ResponseWriter, r *http.Request)
+	MRVACompare(w http.ResponseWriter, r *http.Request)
+	MRVAEvents(w http.ResponseWriter, r *http.Request)
+	AdminListControllers(w http.ResponseWriter, r *http.Request)
+	AdminAddController(w http.ResponseWriter, r *http.Request)
+	AdminRemoveController(w http.ResponseWriter, r *http.Request)
+	AdminWebhookDeliveries(w http.ResponseWriter, r *http.Request)
+	AdminUploadDatabase(w http.ResponseWriter, r *http.Request)
+	MRVAListDatabases(w http.ResponseWriter, r *http.Request)
+	MRVAExportDatabases(w http.ResponseWriter, r *http.Request)
 	MRVADownloadServe(w http.ResponseWriter, r *http.Request)
+	Healthz(w http.ResponseWriter, r *http.Request)
+	Readyz(w http.ResponseWriter, r *http.Request)
+	Version(w http.ResponseWriter, r *http.Request)
 }
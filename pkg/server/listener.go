@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"mrvacommander/config/mcc"
+)
+
+const (
+	defaultListenAddress     = ":8080"
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultShutdownTimeout   = 30 * time.Second
+)
+
+// newHTTPServer configures the listener of the API.
+func newHTTPServer(cfg mcc.HTTP, h http.Handler) *http.Server {
+	addr := cfg.ListenAddress
+	if addr == "" {
+		addr = defaultListenAddress
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: durationOr(cfg.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       durationOr(cfg.IdleTimeout, defaultIdleTimeout),
+	}
+}
+
+// publicURL returns the configured public URL without trailing slash, or
+// one derived from the listen address.
+func publicURL(cfg mcc.HTTP) string {
+	if cfg.PublicURL != "" {
+		return strings.TrimSuffix(cfg.PublicURL, "/")
+	}
+	scheme := "http"
+	if useTLS(cfg) {
+		scheme = "https"
+	}
+	addr := cfg.ListenAddress
+	if addr == "" {
+		addr = defaultListenAddress
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Sprintf("%s://%s", scheme, addr)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, port))
+}
+
+func useTLS(cfg mcc.HTTP) bool {
+	return cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// Serve runs the API until ctx is cancelled, then stops accepting
+// connections and waits up to the shutdown timeout for in-flight requests,
+// such as result downloads, to finish.
+func (c *CommanderSingle) Serve(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "address", c.server.Addr, "tls", useTLS(c.http), "public_url", c.baseURL())
+		var err error
+		if useTLS(c.http) {
+			err = c.server.ListenAndServeTLS(c.http.TLSCertFile, c.http.TLSKeyFile)
+		} else {
+			err = c.server.ListenAndServe()
+		}
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := durationOr(c.http.ShutdownTimeout, defaultShutdownTimeout)
+	slog.Info("Shutting down, draining in-flight requests", "timeout", timeout)
+	sctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := c.server.Shutdown(sctx); err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+// Handler returns the handler serving the API, for use without a listener.
+func (c *CommanderSingle) Handler() http.Handler {
+	return c.server.Handler
+}
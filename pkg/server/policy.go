@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+)
+
+// loadPolicy reads the access policy file, if configured.  A broken policy
+// is a fatal configuration error rather than a reason to allow everything.
+func loadPolicy(fname string) *auth.Policy {
+	if fname == "" {
+		return nil
+	}
+	p, err := auth.LoadPolicyFile(fname)
+	if err != nil {
+		slog.Error("Invalid access policy", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Loaded access policy", "file", fname)
+	return p
+}
+
+// filterAccess removes the repositories id may not analyze from the
+// not-found and no-database lists and from the analysis map, and returns
+// them in request order.  Denied repositories are reported the same way
+// whether or not a database exists, so the response does not reveal what
+// the store holds.
+func (c *CommanderSingle) filterAccess(id auth.Identity, requested []common.NameWithOwner,
+	notFound []common.NameWithOwner, noDB []common.NameWithOwner,
+	analysisRepos *map[common.NameWithOwner]storage.DBLocation) (denied, stillNotFound, stillNoDB []common.NameWithOwner) {
+	denied = []common.NameWithOwner{}
+	for _, nwo := range requested {
+		if !c.policy.Allows(id, nwo) {
+			denied = append(denied, nwo)
+			delete(*analysisRepos, nwo)
+		}
+	}
+	if len(denied) > 0 {
+		slog.Info("Repositories denied by access policy", "actor", id.Login, "count", len(denied))
+	}
+	return denied, c.allowed(id, notFound), c.allowed(id, noDB)
+}
+
+// allowed returns the repositories of list id may analyze.
+func (c *CommanderSingle) allowed(id auth.Identity, list []common.NameWithOwner) []common.NameWithOwner {
+	out := []common.NameWithOwner{}
+	for _, nwo := range list {
+		if c.policy.Allows(id, nwo) {
+			out = append(out, nwo)
+		}
+	}
+	return out
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+)
+
+const defaultJobTimeout = 6 * time.Hour
+
+// quotaUsage is the current consumption of quotas, by one actor or by all.
+type quotaUsage struct {
+	activeSessions int
+	analysesToday  int
+}
+
+// quotaLedger counts the analyses admitted per UTC day and the sessions
+// being started.  Quotas are checked and reserved under its lock, so
+// concurrent submissions cannot both take the last slot of a quota.
+type quotaLedger struct {
+	mutex   sync.Mutex
+	day     time.Time
+	byActor map[string]int
+	total   int
+	// Logins of the sessions admitted but still being started, by id
+	starting map[int]string
+}
+
+func newQuotaLedger() *quotaLedger {
+	return &quotaLedger{byActor: make(map[string]int), starting: make(map[int]string)}
+}
+
+// rollLocked starts a new day's counts once the UTC day has changed.
+func (l *quotaLedger) rollLocked(now time.Time) {
+	if day := startOfDay(now); !day.Equal(l.day) {
+		l.day = day
+		l.byActor = make(map[string]int)
+		l.total = 0
+	}
+}
+
+func (l *quotaLedger) chargeLocked(login string, analyses int, now time.Time) {
+	l.rollLocked(now)
+	l.byActor[login] += analyses
+	l.total += analyses
+}
+
+// settleLocked corrects the analyses charged at reserved by delta, unless
+// the day they were charged to is over.
+func (l *quotaLedger) settleLocked(login string, delta int, reserved, now time.Time) {
+	l.rollLocked(now)
+	if !l.day.Equal(startOfDay(reserved)) {
+		return
+	}
+	l.byActor[login] += delta
+	l.total += delta
+}
+
+// usageLocked returns the usage of the actor with the given login and that
+// of all actors together.  Sessions count as running while they have an
+// analysis pending for less than jobTimeout.
+func (l *quotaLedger) usageLocked(login string, now time.Time, jobTimeout time.Duration) (quotaUsage, quotaUsage) {
+	l.rollLocked(now)
+	actor := quotaUsage{analysesToday: l.byActor[login]}
+	total := quotaUsage{analysesToday: l.total}
+	for _, sn := range storage.ListSessions(func(sn common.Session) bool {
+		_, starting := l.starting[sn.ID]
+		return !starting && storage.IsSessionActive(sn.ID, now.Add(-jobTimeout))
+	}) {
+		total.activeSessions++
+		if sn.Actor.Login == login {
+			actor.activeSessions++
+		}
+	}
+	for _, starter := range l.starting {
+		total.activeSessions++
+		if starter == login {
+			actor.activeSessions++
+		}
+	}
+	return actor, total
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// checkQuota rejects a submission early, before its body is read, if the
+// actor could not start a session now.  Nothing is reserved; admit checks
+// again.
+func (c *CommanderSingle) checkQuota(w http.ResponseWriter, actor common.Actor) bool {
+	c.ledger.mutex.Lock()
+	defer c.ledger.mutex.Unlock()
+	_, ok := c.checkQuotaLocked(w, actor, time.Now())
+	return ok
+}
+
+// admit checks the quotas of actor and, if they allow another session,
+// calls start with the number of repositories it may analyze, or -1 for no
+// limit.  start records the jobs of session vaid, of at most requested
+// repositories, and returns how many analyses it queued and whether it
+// succeeded.
+//
+// The session and its analyses are reserved before start is called, but the
+// quota lock is not held while it runs, since queueing blocks while the
+// queue is full.  The reservation is settled to the analyses actually
+// queued afterwards.
+func (c *CommanderSingle) admit(w http.ResponseWriter, actor common.Actor, vaid, requested int,
+	start func(limit int) (int, bool)) bool {
+	now := time.Now()
+	c.ledger.mutex.Lock()
+	limit, ok := c.checkQuotaLocked(w, actor, now)
+	if !ok {
+		c.ledger.mutex.Unlock()
+		return false
+	}
+	reserved := requested
+	if limit >= 0 {
+		reserved = min(limit, requested)
+	}
+	c.ledger.chargeLocked(actor.Login, reserved, now)
+	c.ledger.starting[vaid] = actor.Login
+	c.ledger.mutex.Unlock()
+
+	analyses, ok := start(limit)
+
+	c.ledger.mutex.Lock()
+	defer c.ledger.mutex.Unlock()
+	delete(c.ledger.starting, vaid)
+	c.ledger.settleLocked(actor.Login, analyses-reserved, now, time.Now())
+	return ok
+}
+
+// checkQuotaLocked rejects a new session with 429 Too Many Requests if the
+// actor, or the server as a whole, has too many sessions running or has
+// used up the daily budget.  It returns how many repositories the session
+// may analyze, or -1 for no limit.
+func (c *CommanderSingle) checkQuotaLocked(w http.ResponseWriter, actor common.Actor, now time.Time) (int, bool) {
+	q := c.quotas
+	u, total := c.ledger.usageLocked(actor.Login, now, c.jobTimeout)
+
+	if q.MaxConcurrentSessions > 0 && u.activeSessions >= q.MaxConcurrentSessions {
+		rejectQuota(w, actor, fmt.Sprintf("Too many variant analyses in progress (limit %d)",
+			q.MaxConcurrentSessions), 0)
+		return 0, false
+	}
+	if q.GlobalMaxConcurrentSessions > 0 && total.activeSessions >= q.GlobalMaxConcurrentSessions {
+		rejectQuota(w, actor, fmt.Sprintf("Too many variant analyses in progress on the server (limit %d)",
+			q.GlobalMaxConcurrentSessions), 0)
+		return 0, false
+	}
+
+	untilTomorrow := startOfDay(now).Add(24 * time.Hour).Sub(now)
+	allowed := remaining(-1, q.DailyAnalysisBudget, u.analysesToday)
+	if allowed == 0 {
+		rejectQuota(w, actor, fmt.Sprintf("Daily analysis budget of %d repositories used up",
+			q.DailyAnalysisBudget), untilTomorrow)
+		return 0, false
+	}
+	allowed = remaining(allowed, q.GlobalDailyAnalysisBudget, total.analysesToday)
+	if allowed == 0 {
+		rejectQuota(w, actor, fmt.Sprintf("Daily analysis budget of the server, %d repositories, used up",
+			q.GlobalDailyAnalysisBudget), untilTomorrow)
+		return 0, false
+	}
+	return remaining(allowed, q.MaxReposPerSession, 0), true
+}
+
+// remaining lowers allowed, where -1 means no limit, to what is left of
+// limit after used.  A limit of 0 is disabled.
+func remaining(allowed, limit, used int) int {
+	if limit <= 0 {
+		return allowed
+	}
+	left := max(limit-used, 0)
+	if allowed < 0 || left < allowed {
+		return left
+	}
+	return allowed
+}
+
+func rejectQuota(w http.ResponseWriter, actor common.Actor, msg string, retry time.Duration) {
+	slog.Warn(msg, "actor", actor.Login)
+	if retry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+	}
+	writeError(w, http.StatusTooManyRequests, msg)
+}
+
+// applyRepoLimit keeps the first limit repositories of analysisRepos, in the
+// order they were requested, and returns the rest as over limit.
+func applyRepoLimit(limit int, requested []common.NameWithOwner,
+	analysisRepos *map[common.NameWithOwner]storage.DBLocation) []common.NameWithOwner {
+	over := []common.NameWithOwner{}
+	if limit < 0 {
+		return over
+	}
+	kept := 0
+	for _, nwo := range requested {
+		if _, ok := (*analysisRepos)[nwo]; !ok {
+			continue
+		}
+		if kept < limit {
+			kept++
+			continue
+		}
+		delete(*analysisRepos, nwo)
+		over = append(over, nwo)
+	}
+	return over
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// controllerID derives a stable positive id from a controller's full name,
+// so that ids survive restarts without being configured.
+func controllerID(owner, name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(owner + "/" + name)))
+	id := int(h.Sum32() & 0x7fffffff)
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// newController validates a controller definition and fills in defaults.
+func newController(id int, owner, name, visibility string) (common.Controller, error) {
+	if owner == "" || name == "" || strings.Contains(owner, "/") || strings.Contains(name, "/") {
+		return common.Controller{}, fmt.Errorf("invalid controller repository %q/%q", owner, name)
+	}
+	switch visibility {
+	case "":
+		visibility = "private"
+	case "public", "private", "internal":
+	default:
+		return common.Controller{}, fmt.Errorf("invalid visibility %q", visibility)
+	}
+	if id == 0 {
+		id = controllerID(owner, name)
+	}
+	if id < 0 {
+		return common.Controller{}, fmt.Errorf("invalid controller id %d", id)
+	}
+	return common.Controller{ID: id, Owner: owner, Name: name, Visibility: visibility}, nil
+}
+
+// registerController adds cr to the registry unless its id or name is
+// already taken by a different controller.
+func registerController(cr common.Controller) error {
+	if other, ok := storage.GetController(cr.ID); ok &&
+		!(strings.EqualFold(other.Owner, cr.Owner) && strings.EqualFold(other.Name, cr.Name)) {
+		return fmt.Errorf("controller id %d is already used by %s/%s", cr.ID, other.Owner, other.Name)
+	}
+	if other, ok := storage.FindController(cr.Owner, cr.Name); ok && other.ID != cr.ID {
+		return fmt.Errorf("controller %s/%s is already registered with id %d", cr.Owner, cr.Name, other.ID)
+	}
+	storage.SetController(cr)
+	return nil
+}
+
+// registerConfiguredControllers seeds the registry from the configuration.
+// Invalid entries are a fatal configuration error.
+func registerConfiguredControllers(crs []mcc.ControllerRepo) {
+	for _, ccr := range crs {
+		cr, err := newController(ccr.ID, ccr.Owner, ccr.Name, ccr.Visibility)
+		if err == nil {
+			err = registerController(cr)
+		}
+		if err != nil {
+			slog.Error("Invalid controller repository configuration", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Registered controller repository", "id", cr.ID, "owner", cr.Owner, "name", cr.Name)
+	}
+	if len(crs) == 0 {
+		slog.Warn("No controller repositories configured, submissions will be rejected until one is registered")
+	}
+}
+
+// controllerRepoResponse renders a controller in the form of the GitHub
+// repository API.
+func (c *CommanderSingle) controllerRepoResponse(cr common.Controller) common.ControllerRepo {
+	if cr.ID == 0 {
+		return common.ControllerRepo{}
+	}
+	full := fmt.Sprintf("%s/%s", cr.Owner, cr.Name)
+	api := fmt.Sprintf("%s/repos/%s", c.baseURL(), full)
+	html := githubServerURL() + "/" + full
+
+	return common.ControllerRepo{
+		ID:         cr.ID,
+		NodeID:     fmt.Sprintf("R_%d", cr.ID),
+		Name:       cr.Name,
+		FullName:   full,
+		Visibility: cr.Visibility,
+		Private:    cr.Visibility != "public",
+		Owner: common.Actor{
+			Login:   cr.Owner,
+			URL:     fmt.Sprintf("%s/users/%s", c.baseURL(), cr.Owner),
+			HTMLURL: githubServerURL() + "/" + cr.Owner,
+			Type:    "Organization",
+		},
+		HTMLURL: html,
+		URL:     api,
+
+		ForksURL:         api + "/forks",
+		KeysURL:          api + "/keys{/key_id}",
+		CollaboratorsURL: api + "/collaborators{/collaborator}",
+		TeamsURL:         api + "/teams",
+		HooksURL:         api + "/hooks",
+		IssueEventsURL:   api + "/issues/events{/number}",
+		EventsURL:        api + "/events",
+
+		AssigneesURL: api + "/assignees{/user}",
+		BranchesURL:  api + "/branches{/branch}",
+		TagsURL:      api + "/tags",
+		BlobsURL:     api + "/git/blobs{/sha}",
+		GitTagsURL:   api + "/git/tags{/sha}",
+		GitRefsURL:   api + "/git/refs{/sha}",
+		TreesURL:     api + "/git/trees{/sha}",
+		StatusesURL:  api + "/statuses/{sha}",
+		LanguagesURL: api + "/languages",
+
+		StargazersURL:   api + "/stargazers",
+		ContributorsURL: api + "/contributors",
+		SubscribersURL:  api + "/subscribers",
+		SubscriptionURL: api + "/subscription",
+
+		CommitsURL:       api + "/commits{/sha}",
+		GitCommitsURL:    api + "/git/commits{/sha}",
+		CommentsURL:      api + "/comments{/number}",
+		IssueCommentURL:  api + "/issues/comments{/number}",
+		ContentsURL:      api + "/contents/{+path}",
+		CompareURL:       api + "/compare/{base}...{head}",
+		MergesURL:        api + "/merges",
+		ArchiveURL:       api + "/{archive_format}{/ref}",
+		DownloadsURL:     api + "/downloads",
+		IssuesURL:        api + "/issues{/number}",
+		PullsURL:         api + "/pulls{/number}",
+		MilestonesURL:    api + "/milestones{/number}",
+		NotificationsURL: api + "/notifications{?since,all,participating}",
+		LabelsURL:        api + "/labels{/name}",
+		ReleasesURL:      api + "/releases{/id}",
+		DeploymentsURL:   api + "/deployments",
+	}
+}
+
+// sessionControllerRepo renders the controller of a session, falling back to
+// its name alone if the controller has since been removed from the registry.
+func (c *CommanderSingle) sessionControllerRepo(sn common.Session) common.ControllerRepo {
+	if cr, ok := storage.FindController(sn.Owner, sn.ControllerRepo); ok {
+		return c.controllerRepoResponse(cr)
+	}
+	return common.ControllerRepo{
+		Name:     sn.ControllerRepo,
+		FullName: fmt.Sprintf("%s/%s", sn.Owner, sn.ControllerRepo),
+	}
+}
+
+func githubServerURL() string {
+	if server := os.Getenv("GITHUB_SERVER_URL"); server != "" {
+		return server
+	}
+	return "https://github.com"
+}
+
+// List registered controller repositories
+func (c *CommanderSingle) AdminListControllers(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, storage.ListControllers())
+}
+
+// Register a controller repository
+func (c *CommanderSingle) AdminAddController(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Problems reading request body")
+		return
+	}
+	var req common.Controller
+	if err := json.Unmarshal(buf, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+
+	cr, err := newController(req.ID, req.Owner, req.Name, req.Visibility)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed", APIErrorDetail{
+			Resource: "ControllerRepo", Code: ErrCodeInvalid, Message: err.Error(),
+		})
+		return
+	}
+	if err := registerController(cr); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed", APIErrorDetail{
+			Resource: "ControllerRepo", Code: ErrCodeAlreadyExists, Message: err.Error(),
+		})
+		return
+	}
+	slog.Info("Registered controller repository", "id", cr.ID, "owner", cr.Owner, "name", cr.Name)
+
+	writeJSON(w, http.StatusCreated, cr)
+}
+
+// Remove a controller repository from the registry
+func (c *CommanderSingle) AdminRemoveController(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["controller_id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	if !storage.RemoveController(id) {
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	slog.Info("Removed controller repository", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON sends v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error encoding response as JSON:",
+			"error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/metrics"
+	"mrvacommander/pkg/storage"
+)
+
+// Re-run a session with its stored query pack.  By default this starts a
+// new session on the same repositories; with ?failed_only=true the
+// repositories whose analysis failed are queued again within the session.
+func (c *CommanderSingle) MRVARerun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	vaid, err := strconv.Atoi(vars["codeql_variant_analysis_id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	if !authorizeSession(w, r, vaid) {
+		return
+	}
+	sn, _ := storage.GetSession(vaid)
+	slog.Info("MRVA rerun", "session", vaid, "failed_only", r.URL.Query().Get("failed_only"))
+
+	if _, err := os.Stat(sn.QueryPack); errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusGone, "The query pack of this variant analysis has expired")
+		return
+	}
+
+	if r.URL.Query().Get("failed_only") == "true" {
+		c.retryFailed(w, r, sn)
+	} else {
+		c.rerunSession(w, r, sn)
+	}
+}
+
+// rerunSession starts a new session like sn on behalf of the requester.
+func (c *CommanderSingle) rerunSession(w http.ResponseWriter, r *http.Request, sn common.Session) {
+	outcome := "rejected"
+	defer func() { metrics.Submissions.WithLabelValues(outcome).Inc() }()
+
+	controller, ok := storage.FindController(sn.Owner, sn.ControllerRepo)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Controller repository is not registered")
+		return
+	}
+
+	identity := auth.FromContext(r.Context())
+	if !c.checkQuota(w, identity.Actor()) {
+		return
+	}
+
+	tgz, err := os.ReadFile(sn.QueryPack)
+	if err != nil {
+		slog.Error("Unable to read stored query pack", "path", sn.QueryPack, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to read query pack")
+		return
+	}
+	id := c.vis.ServerStore.NextID()
+	ref, err := c.vis.ServerStore.SaveQueryPack(tgz, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to store query pack")
+		return
+	}
+
+	rerun := sn
+	rerun.ID = id
+	rerun.Actor = identity.Actor()
+	rerun.QueryPack = ref
+	rerun.CreatedAt = time.Now()
+	slog.Info("Re-running session", "session", sn.ID, "new_session", id)
+
+	if c.admit(w, rerun.Actor, rerun.ID, len(rerun.Repositories), func(repo_limit int) (int, bool) {
+		return c.startSession(w, identity, controller, rerun, repo_limit)
+	}) {
+		outcome = "accepted"
+	}
+}
+
+// retryFailed queues the failed repositories of sn again and replies with
+// the status of the session, listing the repositories that were not queued
+// as skipped.
+func (c *CommanderSingle) retryFailed(w http.ResponseWriter, r *http.Request, sn common.Session) {
+	failed := []common.NameWithOwner{}
+	for _, job := range storage.GetJobList(sn.ID) {
+		switch storage.GetStatus(sn.ID, job.NWO) {
+		case common.StatusError, common.StatusFailed:
+			failed = append(failed, job.NWO)
+		}
+	}
+	if len(failed) == 0 {
+		writeError(w, http.StatusConflict, "No failed repositories to retry")
+		return
+	}
+
+	identity := auth.FromContext(r.Context())
+	skipped := SessionInfo{}
+	if !c.admit(w, identity.Actor(), sn.ID, len(failed), func(repo_limit int) (int, bool) {
+		// Databases or access may have changed since the first run; those
+		// repositories keep their failed status.
+		not_found, no_db, analysisRepos := c.vis.QLDBStore.FindAvailableDBs(failed, sn.Language, sn.Pins)
+		skipped.AccessMismatchRepos, skipped.NotFoundRepos, skipped.NoCodeqlDBRepos =
+			c.filterAccess(identity, failed, not_found, no_db, analysisRepos)
+		skipped.OverLimitRepos = applyRepoLimit(repo_limit, failed, analysisRepos)
+
+		for nwo := range *analysisRepos {
+			storage.SetResult(sn.ID, nwo, common.AnalyzeResult{})
+		}
+		if len(*analysisRepos) > 0 {
+			c.forgetCompleted(sn.ID)
+		}
+
+		slog.Info("Retrying failed repositories", "session", sn.ID, "failed", len(failed),
+			"queued", len(*analysisRepos), "over_limit", len(skipped.OverLimitRepos))
+		c.vis.Queue.StartAnalyses(analysisRepos, sn.ID, sn.Language, sn.QueryPackHash)
+		c.notifyIfComplete(sn.ID)
+		return len(*analysisRepos), true
+	}) {
+		return
+	}
+
+	jobs := storage.GetJobList(sn.ID)
+	js := common.JobSpec{JobID: sn.ID, NameWithOwner: jobs[0].NWO}
+	ji := storage.GetJobInfo(js)
+	ji.SkippedRepositories = skippedRepositories(skipped)
+	c.StatusResponse(w, js, ji, sn.ID)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+)
+
+const defaultSweepInterval = time.Hour
+
+// deleteSession removes a session from storage and forgets the server's own
+// state about it.
+func (c *CommanderSingle) deleteSession(vaid int) error {
+	err := storage.DeleteSession(vaid)
+	c.forgetCompleted(vaid)
+	return err
+}
+
+// sweepLoop applies the retention policy periodically.
+func (c *CommanderSingle) sweepLoop(cfg mcc.Retention) {
+	if cfg.MaxAge == 0 && cfg.MaxTotalBytes == 0 {
+		slog.Info("No retention policy configured, sessions are kept forever")
+		return
+	}
+	interval := durationOr(cfg.SweepInterval, defaultSweepInterval)
+	slog.Info("Retention sweeper started", "max_age", cfg.MaxAge,
+		"max_total_bytes", cfg.MaxTotalBytes, "interval", interval)
+	for {
+		c.sweep(cfg, time.Now())
+		time.Sleep(interval)
+	}
+}
+
+// sweep deletes the completed sessions that are older than the maximum age,
+// then the oldest remaining ones until the disk budget is met.  Query packs
+// and result archives left from earlier runs of the server are deleted by
+// the same rules, before any session.  It returns the ids of the deleted
+// sessions.
+func (c *CommanderSingle) sweep(cfg mcc.Retention, now time.Time) []int {
+	leftovers, err := storage.LeftoverFiles(c.started)
+	if err != nil {
+		slog.Warn("Unable to list leftover files", "error", err)
+	}
+	keptFiles := []storage.StoredFile{}
+	removeFile := func(f storage.StoredFile, reason string) {
+		slog.Info("Deleting leftover file", "path", f.Path, "modified", f.ModTime, "reason", reason)
+		if err := os.Remove(f.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Unable to delete leftover file", "path", f.Path, "error", err)
+		}
+	}
+	for _, f := range leftovers {
+		if cfg.MaxAge > 0 && now.Sub(f.ModTime) > cfg.MaxAge {
+			removeFile(f, "age")
+			continue
+		}
+		keptFiles = append(keptFiles, f)
+	}
+
+	// Oldest first
+	candidates := storage.ListSessions(func(sn common.Session) bool {
+		return storage.IsSessionComplete(sn.ID)
+	})
+	for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+
+	deleted := []int{}
+	remove := func(sn common.Session, reason string) {
+		slog.Info("Expiring session", "id", sn.ID, "created_at", sn.CreatedAt, "reason", reason)
+		if err := c.deleteSession(sn.ID); err != nil {
+			slog.Warn("Incomplete cleanup of expired session", "id", sn.ID, "error", err)
+		}
+		deleted = append(deleted, sn.ID)
+	}
+
+	kept := []common.Session{}
+	for _, sn := range candidates {
+		if cfg.MaxAge > 0 && now.Sub(sn.CreatedAt) > cfg.MaxAge {
+			remove(sn, "age")
+			continue
+		}
+		kept = append(kept, sn)
+	}
+
+	if cfg.MaxTotalBytes > 0 {
+		sizes := make([]int64, len(kept))
+		var total int64
+		// Sessions in progress count against the budget but are not deleted
+		for _, sn := range storage.ListSessions(func(sn common.Session) bool {
+			return !storage.IsSessionComplete(sn.ID)
+		}) {
+			total += storage.SessionSize(sn.ID)
+		}
+		for _, f := range keptFiles {
+			total += f.Size
+		}
+		for i, sn := range kept {
+			sizes[i] = storage.SessionSize(sn.ID)
+			total += sizes[i]
+		}
+		for _, f := range keptFiles {
+			if total <= cfg.MaxTotalBytes {
+				break
+			}
+			removeFile(f, "size")
+			total -= f.Size
+		}
+		for i := 0; i < len(kept) && total > cfg.MaxTotalBytes; i++ {
+			// A session without jobs may be one whose analyses are being
+			// queued right now
+			if len(storage.GetJobList(kept[i].ID)) == 0 {
+				continue
+			}
+			remove(kept[i], "size")
+			total -= sizes[i]
+		}
+	}
+	return deleted
+}
+
+// Delete a session with its query pack and results
+func (c *CommanderSingle) MRVADeleteSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	vaid, err := strconv.Atoi(vars["codeql_variant_analysis_id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	if !authorizeSession(w, r, vaid) {
+		return
+	}
+	if !storage.IsSessionComplete(vaid) {
+		writeError(w, http.StatusConflict, "Variant analysis is still in progress")
+		return
+	}
+	if err := c.deleteSession(vaid); err != nil {
+		slog.Warn("Incomplete cleanup of deleted session", "id", vaid, "error", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -10,30 +10,45 @@ import (
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"mrvacommander/pkg/auth"
 	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/metrics"
+	"mrvacommander/pkg/qldbstore"
+	"mrvacommander/pkg/results"
 	"mrvacommander/pkg/storage"
+	"mrvacommander/utils"
 
 	"github.com/gorilla/mux"
 )
 
-func setupEndpoints(c CommanderAPI) {
+func setupEndpoints(c CommanderAPI) http.Handler {
 	r := mux.NewRouter()
+	r.Use(c.Authenticate)
 
 	// API endpoints that mirror those used in the GitHub API
 	r.HandleFunc("/repos/{owner}/{repo}/code-scanning/codeql/variant-analyses", c.MRVARequest)
-	// Example: /repos/hohn/mrva-controller/code-scanning/codeql/variant-analyses
+	// Example: /repos/hohn/mirva-controller/code-scanning/codeql/variant-analyses
 
 	// Endpoint using repository ID
 	r.HandleFunc("/{repository_id}/code-scanning/codeql/variant-analyses", c.MRVARequestID)
 
+	// Listing and search of sessions
+	r.HandleFunc("/variant-analyses", c.MRVAListSessions).Methods(http.MethodGet)
+
 	// Root handler
 	r.HandleFunc("/", c.RootHandler)
 
+	// Deletion of a session and its results; registered before the status
+	// route, which accepts any method
+	r.HandleFunc("/repos/{owner}/{repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}", c.MRVADeleteSession).Methods(http.MethodDelete)
+
 	// Standalone status request
 	// This is also the first request made when downloading; the difference is in the client-side handling.
 	r.HandleFunc("/repos/{owner}/{repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}", c.MRVAStatus)
@@ -41,16 +56,53 @@ func setupEndpoints(c CommanderAPI) {
 	// Endpoint for downloading artifacts
 	r.HandleFunc("/repos/{controller_owner}/{controller_repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}/repos/{repo_owner}/{repo_name}", c.MRVADownloadArtifact)
 
+	// Endpoint for downloading all results of a session as one SARIF file
+	r.HandleFunc("/repos/{controller_owner}/{controller_repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}/sarif", c.MRVADownloadSarif)
+
+	// Endpoint for downloading the decoded #select results of a session as one CSV file
+	r.HandleFunc("/repos/{controller_owner}/{controller_repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}/csv", c.MRVADownloadCSV)
+
+	// Endpoint for a cross-repository summary of the results of a session
+	r.HandleFunc("/repos/{controller_owner}/{controller_repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}/summary", c.MRVASummary)
+
+	// Endpoint for comparing the results of two sessions
+	r.HandleFunc("/repos/{controller_owner}/{controller_repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}/compare/{head_variant_analysis_id}", c.MRVACompare)
+
+	// Endpoint re-running a session, or with ?failed_only=true its failed repositories
+	r.HandleFunc("/repos/{controller_owner}/{controller_repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}/rerun", c.MRVARerun).Methods(http.MethodPost)
+
+	// Endpoint streaming the progress of a session as Server-Sent Events
+	r.HandleFunc("/repos/{controller_owner}/{controller_repo}/code-scanning/codeql/variant-analyses/{codeql_variant_analysis_id}/events", c.MRVAEvents)
+
 	// Not implemented:
 	// r.HandleFunc("/codeql-query-console/codeql-variant-analysis-repo-tasks/{codeql_variant_analysis_id}/{repo_id}/{owner_id}/{controller_repo_id}", MRVADownLoad3)
 	// r.HandleFunc("/github-codeql-query-console-prod/codeql-variant-analysis-repo-tasks/{codeql_variant_analysis_id}/{repo_id}", MRVADownLoad4)
 
-	// Support API endpoint
-	r.HandleFunc("/download-server/{local_path:.*}", c.MRVADownloadServe)
+	// Admin endpoints for the controller repository registry
+	r.HandleFunc("/admin/controller-repos", c.AdminListControllers).Methods(http.MethodGet)
+	r.HandleFunc("/admin/controller-repos", c.AdminAddController).Methods(http.MethodPost)
+	r.HandleFunc("/admin/controller-repos/{controller_id}", c.AdminRemoveController).Methods(http.MethodDelete)
+
+	// Listing and export of the databases in the store
+	r.HandleFunc("/databases", c.MRVAListDatabases).Methods(http.MethodGet)
+	r.HandleFunc("/databases/export", c.MRVAExportDatabases).Methods(http.MethodGet)
+
+	// Admin endpoint for adding databases to the store
+	r.HandleFunc("/admin/databases/{owner}/{repo}", c.AdminUploadDatabase).Methods(http.MethodPost)
+
+	// Admin endpoint for the webhook delivery log
+	r.HandleFunc("/admin/webhooks/deliveries", c.AdminWebhookDeliveries).Methods(http.MethodGet)
+
+	// Liveness, readiness and build information, e.g. for container health checks
+	r.HandleFunc("/healthz", c.Healthz).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/readyz", c.Readyz).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/version", c.Version).Methods(http.MethodGet)
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+
+	// Support API endpoint, serving result archives by signed token
+	r.HandleFunc("/download-server/{token}", c.MRVADownloadServe)
 
-	// Bind to a port and pass our router in
-	// TODO: Make this a configuration entry
-	log.Fatal(http.ListenAndServe(":8080", r))
+	return r
 }
 
 func (c *CommanderSingle) StatusResponse(w http.ResponseWriter, js common.JobSpec, ji common.JobInfo, vaid int) {
@@ -60,6 +112,7 @@ func (c *CommanderSingle) StatusResponse(w http.ResponseWriter, js common.JobSpe
 	jobs := storage.GetJobList(js.JobID)
 	for _, job := range jobs {
 		astat := storage.GetStatus(js.JobID, job.NWO).ToExternalString()
+		ar := storage.GetResult(common.JobSpec{JobID: js.JobID, NameWithOwner: job.NWO})
 		all_scanned = append(all_scanned,
 			common.ScannedRepo{
 				Repository: common.Repository{
@@ -73,16 +126,19 @@ func (c *CommanderSingle) StatusResponse(w http.ResponseWriter, js common.JobSpe
 				AnalysisStatus:    astat,
 				ResultCount:       123, // FIXME  123 is a lie so the client downloads
 				ArtifactSizeBytes: 123, // FIXME
+				CacheHit:          ar.CacheHit,
 			},
 		)
 	}
 
 	astat := storage.GetStatus(js.JobID, js.NameWithOwner).ToExternalString()
 
+	sn, _ := storage.GetSession(js.JobID)
+
 	status := common.StatusResponse{
 		SessionId:            js.JobID,
-		ControllerRepo:       common.ControllerRepo{},
-		Actor:                common.Actor{},
+		ControllerRepo:       c.sessionControllerRepo(sn),
+		Actor:                sn.Actor,
 		QueryLanguage:        ji.QueryLanguage,
 		QueryPackURL:         "", // FIXME
 		CreatedAt:            ji.CreatedAt,
@@ -98,7 +154,7 @@ func (c *CommanderSingle) StatusResponse(w http.ResponseWriter, js common.JobSpe
 	if err != nil {
 		slog.Error("Error encoding response as JSON:",
 			"error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -117,22 +173,13 @@ func (c *CommanderSingle) MRVAStatus(w http.ResponseWriter, r *http.Request) {
 		"owner", vars["owner"],
 		"repo", vars["repo"],
 		"codeql_variant_analysis_id", vars["codeql_variant_analysis_id"])
-	id, err := strconv.Atoi(vars["codeql_variant_analysis_id"])
-	if err != nil {
-		slog.Error("Variant analysis is is not integer", "id",
-			vars["codeql_variant_analysis_id"])
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	id, ok := sessionID(w, r, "codeql_variant_analysis_id")
+	if !ok {
 		return
 	}
 	// The status reports one status for all jobs belonging to an id.
 	// So we simply report the status of a job as the status of all.
 	spec := storage.GetJobList(id)
-	if spec == nil {
-		msg := "No jobs found for given job id"
-		slog.Error(msg, "id", vars["codeql_variant_analysis_id"])
-		http.Error(w, msg, http.StatusUnprocessableEntity)
-		return
-	}
 
 	job := spec[0]
 
@@ -156,11 +203,8 @@ func (c *CommanderSingle) MRVADownloadArtifact(w http.ResponseWriter, r *http.Re
 		"repo_owner", vars["repo_owner"],
 		"repo_name", vars["repo_name"],
 	)
-	vaid, err := strconv.Atoi(vars["codeql_variant_analysis_id"])
-	if err != nil {
-		slog.Error("Variant analysis is is not integer", "id",
-			vars["codeql_variant_analysis_id"])
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	vaid, ok := sessionID(w, r, "codeql_variant_analysis_id")
+	if !ok {
 		return
 	}
 	js := common.JobSpec{
@@ -181,11 +225,7 @@ func (c *CommanderSingle) DownloadResponse(w http.ResponseWriter, js common.JobS
 	var dlr common.DownloadResponse
 	if astat == common.StatusSuccess {
 
-		au, err := storage.ArtifactURL(js, vaid)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+		au := c.artifactURL(js)
 
 		dlr = common.DownloadResponse{
 			Repository: common.DownloadRepo{
@@ -219,7 +259,7 @@ func (c *CommanderSingle) DownloadResponse(w http.ResponseWriter, js common.JobS
 	if err != nil {
 		slog.Error("Error encoding response as JSON:",
 			"error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -229,73 +269,396 @@ func (c *CommanderSingle) DownloadResponse(w http.ResponseWriter, js common.JobS
 
 }
 
+// Download the merged SARIF of all succeeded repositories in a session
+func (c *CommanderSingle) MRVADownloadSarif(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	slog.Info("MRVA merged SARIF download",
+		"controller_owner", vars["controller_owner"],
+		"controller_repo", vars["controller_repo"],
+		"codeql_variant_analysis_id", vars["codeql_variant_analysis_id"],
+	)
+	vaid, ok := sessionID(w, r, "codeql_variant_analysis_id")
+	if !ok {
+		return
+	}
+
+	archives, err := succeededArchives(vaid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=variant-analysis-%d.sarif", vaid))
+	w.Header().Set("Content-Type", "application/sarif+json")
+
+	// The response is streamed, so errors past this point can only be logged.
+	if err := results.MergeSarif(w, archives); err != nil {
+		slog.Error("Failed to send merged SARIF", "session", vaid, "error", err)
+	}
+}
+
+// Download the combined CSV of all succeeded repositories in a session
+func (c *CommanderSingle) MRVADownloadCSV(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	slog.Info("MRVA combined CSV download",
+		"controller_owner", vars["controller_owner"],
+		"controller_repo", vars["controller_repo"],
+		"codeql_variant_analysis_id", vars["codeql_variant_analysis_id"],
+	)
+	vaid, ok := sessionID(w, r, "codeql_variant_analysis_id")
+	if !ok {
+		return
+	}
+
+	archives, err := succeededArchives(vaid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Tables of different queries only fit in one CSV if their columns
+	// agree; otherwise they are downloaded one query at a time
+	query := r.URL.Query().Get("query")
+	if _, err := results.CSVColumns(archives, query); err != nil {
+		if errors.Is(err, results.ErrMixedColumns) {
+			writeError(w, http.StatusUnprocessableEntity,
+				"Queries have different columns, select one with the query parameter",
+				APIErrorDetail{Resource: "VariantAnalysis", Field: "query", Code: ErrCodeUnprocessable, Message: err.Error()})
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=variant-analysis-%d.csv", vaid))
+	w.Header().Set("Content-Type", "text/csv")
+
+	// The response is streamed, so errors past this point can only be logged.
+	if err := results.CombineCSV(w, archives, query); err != nil {
+		slog.Error("Failed to send combined CSV", "session", vaid, "error", err)
+	}
+}
+
+// Summarize the findings of all succeeded repositories in a session
+func (c *CommanderSingle) MRVASummary(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	slog.Info("MRVA result summary",
+		"controller_owner", vars["controller_owner"],
+		"controller_repo", vars["controller_repo"],
+		"codeql_variant_analysis_id", vars["codeql_variant_analysis_id"],
+	)
+	vaid, ok := sessionID(w, r, "codeql_variant_analysis_id")
+	if !ok {
+		return
+	}
+
+	top, err := queryInt(r, "top", 10)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "", invalidParam("top", err))
+		return
+	}
+	samples, err := queryInt(r, "samples", 3)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "", invalidParam("samples", err))
+		return
+	}
+
+	archives, err := succeededArchives(vaid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	summary := results.Summarize(vaid, archives, results.SummaryOptions{
+		TopRepositories: top,
+		SamplesPerRule:  samples,
+	})
+
+	jsum, err := json.Marshal(summary)
+	if err != nil {
+		slog.Error("Error encoding response as JSON:",
+			"error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsum)
+}
+
+// Compare the findings of two sessions, usually runs of two versions of a
+// query on the same repository list
+func (c *CommanderSingle) MRVACompare(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	slog.Info("MRVA session comparison",
+		"controller_owner", vars["controller_owner"],
+		"controller_repo", vars["controller_repo"],
+		"codeql_variant_analysis_id", vars["codeql_variant_analysis_id"],
+		"head_variant_analysis_id", vars["head_variant_analysis_id"],
+	)
+
+	ids := []int{}
+	for _, key := range []string{"codeql_variant_analysis_id", "head_variant_analysis_id"} {
+		vaid, ok := sessionID(w, r, key)
+		if !ok {
+			return
+		}
+		ids = append(ids, vaid)
+	}
+
+	details := r.URL.Query().Get("details") != "false"
+
+	base, err := succeededArchives(ids[0])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	head, err := succeededArchives(ids[1])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cmp := results.Compare(ids[0], base, ids[1], head, details)
+
+	jcmp, err := json.Marshal(cmp)
+	if err != nil {
+		slog.Error("Error encoding response as JSON:",
+			"error", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jcmp)
+}
+
+// sessionID reads the session id in path variable key and checks that the
+// session exists, has jobs and is accessible to the requester, replying with
+// an error if not.  Malformed ids are reported like unknown ones, as GitHub
+// does.
+func sessionID(w http.ResponseWriter, r *http.Request, key string) (int, bool) {
+	vaid, err := strconv.Atoi(mux.Vars(r)[key])
+	if err != nil {
+		slog.Warn("Variant analysis id is not an integer", "id", mux.Vars(r)[key])
+		writeError(w, http.StatusNotFound, "")
+		return 0, false
+	}
+	if !authorizeSession(w, r, vaid) {
+		return 0, false
+	}
+	if storage.GetJobList(vaid) == nil {
+		slog.Error("No jobs found for given job id", "id", vaid)
+		writeError(w, http.StatusNotFound, "")
+		return 0, false
+	}
+	return vaid, true
+}
+
+func invalidParam(name string, err error) APIErrorDetail {
+	return APIErrorDetail{Resource: "VariantAnalysis", Field: name, Code: ErrCodeInvalid, Message: err.Error()}
+}
+
+// queryInt reads a non-negative integer query parameter, returning def when
+// the parameter is absent.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	val := r.URL.Query().Get(name)
+	if val == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %q", name, val)
+	}
+	return n, nil
+}
+
+// succeededArchives lists the stored result archives of all repositories in
+// a session whose analysis succeeded.
+func succeededArchives(vaid int) ([]results.RepoArchive, error) {
+	archives := []results.RepoArchive{}
+	for _, job := range storage.GetJobList(vaid) {
+		if storage.GetStatus(vaid, job.NWO) != common.StatusSuccess {
+			continue
+		}
+		zpath, err := storage.ResultArchivePath(job.NWO, vaid)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, results.RepoArchive{NWO: job.NWO, Path: zpath})
+	}
+	return archives, nil
+}
+
 func (c *CommanderSingle) MRVADownloadServe(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	slog.Info("File download request", "local_path", vars["local_path"])
 
-	FileDownload(w, vars["local_path"])
+	js, err := c.signer.Verify(vars["token"])
+	if errors.Is(err, ErrExpiredArtifactToken) {
+		slog.Info("Expired artifact token")
+		writeError(w, http.StatusGone, "Download link expired")
+		return
+	}
+	if err != nil {
+		slog.Warn("Rejected artifact token", "error", err)
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	slog.Info("File download request", "session", js.JobID, "owner/repo", js.NameWithOwner)
+
+	// A valid token is not enough: the job must still exist and have succeeded
+	if storage.GetStatus(js.JobID, js.NameWithOwner) != common.StatusSuccess {
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	zpath, err := storage.ResultArchivePath(js.NameWithOwner, js.JobID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read results")
+		return
+	}
+
+	FileDownload(w, r, zpath)
+}
+
+// artifactURL returns a signed download URL for the result archive of js.
+func (c *CommanderSingle) artifactURL(js common.JobSpec) string {
+	return fmt.Sprintf("%s/download-server/%s", c.baseURL(), c.signer.Sign(js))
 }
 
-func FileDownload(w http.ResponseWriter, path string) {
-	slog.Debug("Sending zip file with .sarif/.bqrs", "path", path)
+// baseURL is the URL under which clients reach this server.
+func (c *CommanderSingle) baseURL() string {
+	return publicURL(c.http)
+}
+
+func FileDownload(w http.ResponseWriter, r *http.Request, fpath string) {
+	slog.Debug("Sending zip file with .sarif/.bqrs", "path", fpath)
+
+	file, err := os.Open(fpath)
+	if err != nil {
+		slog.Warn("Failed to read results file", "path", fpath, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to read results")
+		return
+	}
+	defer file.Close()
 
-	fpath, res, err := storage.ResultAsFile(path)
+	fi, err := file.Stat()
 	if err != nil {
-		http.Error(w, "Failed to read results", http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to read results")
 		return
 	}
+
 	// Set headers
 	fname := filepath.Base(fpath)
 	w.Header().Set("Content-Disposition", "attachment; filename="+fname)
 	w.Header().Set("Content-Type", "application/octet-stream")
 
-	// Copy the file contents to the response writer
-	rdr := bytes.NewReader(res)
-	_, err = io.Copy(w, rdr)
-	if err != nil {
-		http.Error(w, "Failed to send file", http.StatusInternalServerError)
-		return
-	}
+	http.ServeContent(w, r, fname, fi.ModTime(), file)
 
 	slog.Debug("Uploaded file", "path", fpath)
-
 }
 
 func (c *CommanderSingle) MRVARequestID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	slog.Info("New mrva using repository_id=%v\n", vars["repository_id"])
+	slog.Info("New mrva using repository_id", "repository_id", vars["repository_id"])
+
+	id, err := strconv.Atoi(vars["repository_id"])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "")
+		return
+	}
+	cr, ok := storage.GetController(id)
+	if !ok {
+		msg := "No controller repository registered with given id"
+		slog.Error(msg, "id", id)
+		writeError(w, http.StatusNotFound, msg)
+		return
+	}
+
+	c.MRVARequest(w, mux.SetURLVars(r, map[string]string{"owner": cr.Owner, "repo": cr.Name}))
 }
 
 func (c *CommanderSingle) MRVARequest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	slog.Info("New mrva run ", "owner", vars["owner"], "repo", vars["repo"])
 
-	session_id := c.vis.ServerStore.NextID()
-	session_owner := vars["owner"]
-	session_controller_repo := vars["repo"]
-	slog.Info("new run", "id: ", fmt.Sprint(session_id), session_owner, session_controller_repo)
-	session_language, session_repositories, session_tgz_ref, err := c.collectRequestInfo(w, r, session_id)
-	if err != nil {
+	outcome := "rejected"
+	defer func() { metrics.Submissions.WithLabelValues(outcome).Inc() }()
+
+	controller, ok := storage.FindController(vars["owner"], vars["repo"])
+	if !ok {
+		msg := "Controller repository is not registered"
+		slog.Error(msg, "owner", vars["owner"], "repo", vars["repo"])
+		writeError(w, http.StatusNotFound, msg)
 		return
 	}
 
-	not_found_repos, analysisRepos := c.vis.ServerStore.FindAvailableDBs(session_repositories)
+	identity := auth.FromContext(r.Context())
+	session_actor := identity.Actor()
+	if !c.checkQuota(w, session_actor) {
+		return
+	}
 
-	c.vis.Queue.StartAnalyses(analysisRepos, session_id, session_language)
+	session_id := c.vis.ServerStore.NextID()
+	session_owner := controller.Owner
+	session_controller_repo := controller.Name
+	slog.Info("new run", "id", fmt.Sprint(session_id), "owner", session_owner, "controller_repo", session_controller_repo)
+	session_language, session_repositories, session_pins, session_tgz_ref, session_tgz_hash, err := c.collectRequestInfo(w, r, session_id)
+	if err != nil {
+		return
+	}
 
-	si := SessionInfo{
+	sn := common.Session{
 		ID:             session_id,
 		Owner:          session_owner,
 		ControllerRepo: session_controller_repo,
+		Actor:          session_actor,
+		QueryPack:      session_tgz_ref,
+		QueryPackHash:  session_tgz_hash,
+		Language:       session_language,
+		Repositories:   session_repositories,
+		Pins:           session_pins,
+		CreatedAt:      time.Now(),
+	}
+	if c.admit(w, session_actor, sn.ID, len(sn.Repositories), func(repo_limit int) (int, bool) {
+		return c.startSession(w, identity, controller, sn, repo_limit)
+	}) {
+		outcome = "accepted"
+	}
+}
 
-		QueryPack:    session_tgz_ref,
-		Language:     session_language,
-		Repositories: session_repositories,
+// startSession stores a new session, queues the analyses of the
+// repositories the actor may analyze and replies with the submission
+// response.  It returns the number of analyses queued.
+func (c *CommanderSingle) startSession(w http.ResponseWriter, identity auth.Identity,
+	controller common.Controller, sn common.Session, repo_limit int) (int, bool) {
+	storage.SetSession(sn)
 
-		AccessMismatchRepos: nil, /* FIXME */
+	not_found_repos, no_db_repos, analysisRepos := c.vis.QLDBStore.FindAvailableDBs(sn.Repositories, sn.Language, sn.Pins)
+	access_mismatch_repos, not_found_repos, no_db_repos := c.filterAccess(identity, sn.Repositories,
+		not_found_repos, no_db_repos, analysisRepos)
+	over_limit_repos := applyRepoLimit(repo_limit, sn.Repositories, analysisRepos)
+
+	c.vis.Queue.StartAnalyses(analysisRepos, sn.ID, sn.Language, sn.QueryPackHash)
+	c.notifyIfComplete(sn.ID)
+
+	si := SessionInfo{
+		ID:             sn.ID,
+		Owner:          sn.Owner,
+		ControllerRepo: sn.ControllerRepo,
+		Controller:     c.controllerRepoResponse(controller),
+		Actor:          sn.Actor,
+
+		QueryPack:     sn.QueryPack,
+		QueryPackHash: sn.QueryPackHash,
+		Language:      sn.Language,
+		Repositories:  sn.Repositories,
+
+		AccessMismatchRepos: access_mismatch_repos,
 		NotFoundRepos:       not_found_repos,
-		NoCodeqlDBRepos:     nil, /* FIXME */
-		OverLimitRepos:      nil, /* FIXME */
+		NoCodeqlDBRepos:     no_db_repos,
+		OverLimitRepos:      over_limit_repos,
 
 		AnalysisRepos: analysisRepos,
 	}
@@ -303,12 +666,13 @@ func (c *CommanderSingle) MRVARequest(w http.ResponseWriter, r *http.Request) {
 	slog.Debug("Forming and sending response for submitted analysis job", "id", si.ID)
 	submit_response, err := submit_response(si)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return len(*analysisRepos), false
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(submit_response)
+	return len(*analysisRepos), true
 }
 
 func nwoToNwoStringArray(nwo []common.NameWithOwner) ([]string, int) {
@@ -320,11 +684,9 @@ func nwoToNwoStringArray(nwo []common.NameWithOwner) ([]string, int) {
 	return repos, count
 }
 
-func submit_response(sn SessionInfo) ([]byte, error) {
-	// Construct the response bottom-up
-	var m_cr common.ControllerRepo
-	var m_ac common.Actor
-
+// skippedRepositories lists the repositories of a submission that are not
+// analyzed, by reason.
+func skippedRepositories(sn SessionInfo) common.SkippedRepositories {
 	repos, count := nwoToNwoStringArray(sn.NotFoundRepos)
 	r_nfr := common.NotFoundRepos{RepositoryCount: count, RepositoryFullNames: repos}
 
@@ -334,15 +696,21 @@ func submit_response(sn SessionInfo) ([]byte, error) {
 	repos, count = nwoToNwoStringArray(sn.NoCodeqlDBRepos)
 	r_ncd := common.NoCodeqlDBRepos{RepositoryCount: count, Repositories: repos}
 
-	// TODO fill these with real values?
-	repos, count = nwoToNwoStringArray(sn.NoCodeqlDBRepos)
+	repos, count = nwoToNwoStringArray(sn.OverLimitRepos)
 	r_olr := common.OverLimitRepos{RepositoryCount: count, Repositories: repos}
 
-	m_skip := common.SkippedRepositories{
+	return common.SkippedRepositories{
 		AccessMismatchRepos: r_amr,
 		NotFoundRepos:       r_nfr,
 		NoCodeqlDBRepos:     r_ncd,
 		OverLimitRepos:      r_olr}
+}
+
+func submit_response(sn SessionInfo) ([]byte, error) {
+	// Construct the response bottom-up
+	m_cr := sn.Controller
+	m_ac := sn.Actor
+	m_skip := skippedRepositories(sn)
 
 	m_sr := common.SubmitResponse{
 		Actor:               m_ac,
@@ -375,35 +743,34 @@ func submit_response(sn SessionInfo) ([]byte, error) {
 	// Encode the response as JSON
 	submit_response, err := json.Marshal(m_sr)
 	if err != nil {
-		slog.Warn("Error encoding response as JSON:", err)
+		slog.Warn("Error encoding response as JSON:", "error", err)
 		return nil, err
 	}
 	return submit_response, nil
 
 }
 
-func (c *CommanderSingle) collectRequestInfo(w http.ResponseWriter, r *http.Request, sessionId int) (string, []common.NameWithOwner, string, error) {
+func (c *CommanderSingle) collectRequestInfo(w http.ResponseWriter, r *http.Request, sessionId int) (string, []common.NameWithOwner, map[common.NameWithOwner]string, string, string, error) {
 	slog.Debug("Collecting session info")
 
-	if r.Body == nil {
+	if r.Body == nil || r.ContentLength == 0 {
 		err := errors.New("missing request body")
-		log.Println(err)
-		http.Error(w, err.Error(), http.StatusNoContent)
-		return "", []common.NameWithOwner{}, "", err
+		slog.Error("Empty MRVA submission body")
+		writeError(w, http.StatusBadRequest, "Requires a request body")
+		return "", []common.NameWithOwner{}, nil, "", "", err
 	}
 	buf, err := io.ReadAll(r.Body)
 	if err != nil {
-		var w http.ResponseWriter
 		slog.Error("Error reading MRVA submission body", "error", err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return "", []common.NameWithOwner{}, "", err
+		writeError(w, http.StatusBadRequest, "Problems reading request body")
+		return "", []common.NameWithOwner{}, nil, "", "", err
 	}
 	msg, err := TrySubmitMsg(buf)
 	if err != nil {
 		// Unknown message
-		slog.Error("Unknown MRVA submission body format")
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return "", []common.NameWithOwner{}, "", err
+		slog.Error("Unknown MRVA submission body format", "error", err)
+		writeError(w, http.StatusBadRequest, "Problems parsing JSON")
+		return "", []common.NameWithOwner{}, nil, "", "", err
 	}
 	// Decompose the SubmitMsg and keep information
 
@@ -411,13 +778,11 @@ func (c *CommanderSingle) collectRequestInfo(w http.ResponseWriter, r *http.Requ
 	if !isBase64Gzip([]byte(msg.QueryPack)) {
 		slog.Error("MRVA submission body querypack has invalid format")
 		err := errors.New("MRVA submission body querypack has invalid format")
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return "", []common.NameWithOwner{}, "", err
-	}
-	session_tgz_ref, err := c.extract_tgz(msg.QueryPack, sessionId)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return "", []common.NameWithOwner{}, "", err
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed", APIErrorDetail{
+			Resource: "VariantAnalysis", Field: "query_pack", Code: ErrCodeInvalid,
+			Message: "query_pack must be a base64-encoded gzipped tar archive",
+		})
+		return "", []common.NameWithOwner{}, nil, "", "", err
 	}
 
 	// 2. Save the language
@@ -425,18 +790,50 @@ func (c *CommanderSingle) collectRequestInfo(w http.ResponseWriter, r *http.Requ
 
 	// 3. Save the repositories
 	var session_repositories []common.NameWithOwner
+	session_pins := map[common.NameWithOwner]string{}
 
 	for _, v := range msg.Repositories {
-		t := strings.Split(v, "/")
-		if len(t) != 2 {
-			err := "Invalid owner / repository entry"
-			slog.Error(err, "entry", t)
-			http.Error(w, err, http.StatusBadRequest)
+		// owner/repo@sha pins the database to a commit
+		entry, pin, pinned := strings.Cut(v, "@")
+		if pinned && !commitPattern.MatchString(pin) {
+			err := errors.New("invalid commit pin")
+			slog.Error("Invalid commit pin", "entry", v)
+			writeError(w, http.StatusUnprocessableEntity, "Validation Failed", APIErrorDetail{
+				Resource: "VariantAnalysis", Field: "repositories", Code: ErrCodeInvalid,
+				Message: fmt.Sprintf("%q does not pin a commit SHA", v),
+			})
+			return "", []common.NameWithOwner{}, nil, "", "", err
+		}
+		t := strings.Split(entry, "/")
+		if len(t) != 2 || !qldbstore.ValidName(t[0]) || !qldbstore.ValidName(t[1]) {
+			err := errors.New("invalid owner / repository entry")
+			slog.Error("Invalid owner / repository entry", "entry", v)
+			writeError(w, http.StatusUnprocessableEntity, "Validation Failed", APIErrorDetail{
+				Resource: "VariantAnalysis", Field: "repositories", Code: ErrCodeInvalid,
+				Message: fmt.Sprintf("%q is not of the form owner/repo", v),
+			})
+			return "", []common.NameWithOwner{}, nil, "", "", err
+		}
+		nwo := common.NameWithOwner{Owner: t[0], Repo: t[1]}
+		session_repositories = append(session_repositories, nwo)
+		if pinned {
+			session_pins[nwo] = pin
 		}
-		session_repositories = append(session_repositories,
-			common.NameWithOwner{Owner: t[0], Repo: t[1]})
 	}
-	return session_language, session_repositories, session_tgz_ref, nil
+
+	// Only keep the query pack once the submission is known to be valid
+	session_tgz_ref, session_tgz_hash, err := c.extract_tgz(msg.QueryPack, sessionId)
+	if err != nil && !errors.Is(err, errInvalidQueryPack) {
+		writeError(w, http.StatusInternalServerError, "Failed to store query pack")
+		return "", []common.NameWithOwner{}, nil, "", "", err
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed", APIErrorDetail{
+			Resource: "VariantAnalysis", Field: "query_pack", Code: ErrCodeInvalid, Message: err.Error(),
+		})
+		return "", []common.NameWithOwner{}, nil, "", "", err
+	}
+	return session_language, session_repositories, session_pins, session_tgz_ref, session_tgz_hash, nil
 }
 
 // Try to extract a SubmitMsg from a json-encoded buffer
@@ -475,7 +872,12 @@ func isBase64Gzip(val []byte) bool {
 	}
 }
 
-func (c *CommanderSingle) extract_tgz(qp string, sessionID int) (string, error) {
+var errInvalidQueryPack = errors.New("invalid query pack")
+
+// commitPattern matches a full or abbreviated commit SHA.
+var commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+func (c *CommanderSingle) extract_tgz(qp string, sessionID int) (string, string, error) {
 	// These are decoded manually via
 	//    base64 -d < foo1 | gunzip | tar t | head -20
 	// base64 decode the body
@@ -483,14 +885,21 @@ func (c *CommanderSingle) extract_tgz(qp string, sessionID int) (string, error)
 
 	tgz, err := base64.StdEncoding.DecodeString(qp)
 	if err != nil {
-		slog.Error("querypack body decoding error:", err)
-		return "", err
+		slog.Error("querypack body decoding error:", "error", err)
+		return "", "", fmt.Errorf("%w: %v", errInvalidQueryPack, err)
+	}
+
+	// The content hash identifies the query pack for the result cache
+	session_query_pack_hash, err := utils.TarGzContentHash(tgz)
+	if err != nil {
+		slog.Error("querypack is not a valid tar.gz", "error", err)
+		return "", "", fmt.Errorf("%w: %v", errInvalidQueryPack, err)
 	}
 
 	session_query_pack_tgz_filepath, err := c.vis.ServerStore.SaveQueryPack(tgz, sessionID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return session_query_pack_tgz_filepath, err
+	return session_query_pack_tgz_filepath, session_query_pack_hash, err
 }
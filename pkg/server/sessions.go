@@ -0,0 +1,210 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+)
+
+const (
+	defaultPerPage = 30
+	maxPerPage     = 100
+)
+
+// SessionListItem describes one session in a session listing.
+type SessionListItem struct {
+	ID              int          `json:"id"`
+	ControllerRepo  string       `json:"controller_repo"`
+	Actor           common.Actor `json:"actor"`
+	QueryLanguage   string       `json:"query_language"`
+	QueryPackHash   string       `json:"query_pack_hash"`
+	Status          string       `json:"status"`
+	RepositoryCount int          `json:"repository_count"`
+	CreatedAt       time.Time    `json:"created_at"`
+	URL             string       `json:"url"`
+}
+
+type SessionList struct {
+	TotalCount      int               `json:"total_count"`
+	VariantAnalyses []SessionListItem `json:"variant_analyses"`
+}
+
+// sessionFilter holds the query parameters of a session listing.
+type sessionFilter struct {
+	actor         string
+	controller    string
+	language      string
+	status        string
+	queryPackHash string
+	after         time.Time
+	before        time.Time
+}
+
+func parseSessionFilter(q url.Values) (sessionFilter, []APIErrorDetail) {
+	f := sessionFilter{
+		actor:         q.Get("actor"),
+		controller:    q.Get("controller_repo"),
+		language:      q.Get("language"),
+		status:        q.Get("status"),
+		queryPackHash: q.Get("query_pack_hash"),
+	}
+	var errs []APIErrorDetail
+	switch f.status {
+	case "", "in_progress", "succeeded", "failed":
+	default:
+		errs = append(errs, APIErrorDetail{Resource: "VariantAnalysis", Field: "status", Code: ErrCodeInvalid,
+			Message: "status must be one of in_progress, succeeded, failed"})
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+		end  bool
+	}{{"created_after", &f.after, false}, {"created_before", &f.before, true}} {
+		val := q.Get(p.name)
+		if val == "" {
+			continue
+		}
+		t, err := parseTimeParam(val, p.end)
+		if err != nil {
+			errs = append(errs, APIErrorDetail{Resource: "VariantAnalysis", Field: p.name, Code: ErrCodeInvalid,
+				Message: "expected an RFC 3339 timestamp or a YYYY-MM-DD date"})
+			continue
+		}
+		*p.t = t
+	}
+	return f, errs
+}
+
+// parseTimeParam accepts RFC 3339 timestamps and dates.  A date used as an
+// upper bound includes the whole day.
+func parseTimeParam(val string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, val)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, nil
+}
+
+func (f sessionFilter) match(sn common.Session) bool {
+	if f.actor != "" && !strings.EqualFold(sn.Actor.Login, f.actor) {
+		return false
+	}
+	if f.controller != "" && !strings.EqualFold(sn.Owner+"/"+sn.ControllerRepo, f.controller) {
+		return false
+	}
+	if f.language != "" && !strings.EqualFold(sn.Language, f.language) {
+		return false
+	}
+	if f.queryPackHash != "" && sn.QueryPackHash != f.queryPackHash {
+		return false
+	}
+	if !f.after.IsZero() && sn.CreatedAt.Before(f.after) {
+		return false
+	}
+	if !f.before.IsZero() && !sn.CreatedAt.Before(f.before) {
+		return false
+	}
+	if f.status != "" && sessionStatus(sn.ID) != f.status {
+		return false
+	}
+	return true
+}
+
+// List sessions, newest first, filtered by the query parameters actor,
+// controller_repo, language, status, query_pack_hash, created_after and
+// created_before, and paginated with page and per_page.  Non-admins only
+// see their own sessions.
+func (c *CommanderSingle) MRVAListSessions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f, errs := parseSessionFilter(q)
+	page, perPage, perrs := pageParams(r, "VariantAnalysis")
+	errs = append(errs, perrs...)
+	if len(errs) > 0 {
+		writeError(w, http.StatusUnprocessableEntity, "Validation Failed", errs...)
+		return
+	}
+
+	id := auth.FromContext(r.Context())
+	sessions := storage.ListSessions(func(sn common.Session) bool {
+		return id.CanAccess(sn.Actor) && f.match(sn)
+	})
+
+	list := SessionList{TotalCount: len(sessions), VariantAnalyses: []SessionListItem{}}
+	first := (page - 1) * perPage
+	for i := first; i < len(sessions) && i < first+perPage; i++ {
+		list.VariantAnalyses = append(list.VariantAnalyses, c.sessionListItem(sessions[i]))
+	}
+
+	lastPage := (len(sessions) + perPage - 1) / perPage
+	if link := paginationLinks(c.baseURL()+r.URL.Path, q, page, lastPage); link != "" {
+		w.Header().Set("Link", link)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (c *CommanderSingle) sessionListItem(sn common.Session) SessionListItem {
+	controller := fmt.Sprintf("%s/%s", sn.Owner, sn.ControllerRepo)
+	return SessionListItem{
+		ID:              sn.ID,
+		ControllerRepo:  controller,
+		Actor:           sn.Actor,
+		QueryLanguage:   sn.Language,
+		QueryPackHash:   sn.QueryPackHash,
+		Status:          sessionStatus(sn.ID),
+		RepositoryCount: len(sn.Repositories),
+		CreatedAt:       sn.CreatedAt,
+		URL:             fmt.Sprintf("%s/repos/%s/code-scanning/codeql/variant-analyses/%d", c.baseURL(), controller, sn.ID),
+	}
+}
+
+// pageParams parses the page and per_page parameters of a listing of
+// resource, capping per_page at maxPerPage.
+func pageParams(r *http.Request, resource string) (page, perPage int, errs []APIErrorDetail) {
+	page, err := queryInt(r, "page", 1)
+	if err != nil || page < 1 {
+		errs = append(errs, APIErrorDetail{Resource: resource, Field: "page", Code: ErrCodeInvalid})
+	}
+	perPage, err = queryInt(r, "per_page", defaultPerPage)
+	if err != nil || perPage < 1 {
+		errs = append(errs, APIErrorDetail{Resource: resource, Field: "per_page", Code: ErrCodeInvalid})
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	return page, perPage, errs
+}
+
+// paginationLinks builds a Link header in the style of the GitHub API.
+func paginationLinks(base string, q url.Values, page, lastPage int) string {
+	pageURL := func(p int) string {
+		v := url.Values{}
+		for k, vals := range q {
+			v[k] = vals
+		}
+		v.Set("page", strconv.Itoa(p))
+		return base + "?" + v.Encode()
+	}
+	links := []string{}
+	if page < lastPage {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(page+1)),
+			fmt.Sprintf(`<%s>; rel="last"`, pageURL(lastPage)))
+	}
+	if page > 1 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="first"`, pageURL(1)),
+			fmt.Sprintf(`<%s>; rel="prev"`, pageURL(min(page-1, max(lastPage, 1)))))
+	}
+	return strings.Join(links, ", ")
+}
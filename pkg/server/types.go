@@ -1,22 +1,33 @@
 package server
 
 import (
+	"net/http"
+	"sync"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/auth"
 	"mrvacommander/pkg/common"
 	"mrvacommander/pkg/logger"
+	"mrvacommander/pkg/metrics"
 	"mrvacommander/pkg/qldbstore"
 	"mrvacommander/pkg/qpstore"
 	"mrvacommander/pkg/queue"
 	"mrvacommander/pkg/storage"
+	"mrvacommander/pkg/webhook"
 )
 
 type SessionInfo struct {
 	ID             int
 	Owner          string
 	ControllerRepo string
+	Controller     common.ControllerRepo
+	Actor          common.Actor
 
-	QueryPack    string
-	Language     string
-	Repositories []common.NameWithOwner
+	QueryPack     string
+	QueryPackHash string
+	Language      string
+	Repositories  []common.NameWithOwner
 
 	AccessMismatchRepos []common.NameWithOwner
 	NotFoundRepos       []common.NameWithOwner
@@ -27,13 +38,57 @@ type SessionInfo struct {
 }
 
 type CommanderSingle struct {
-	vis *Visibles
+	vis    *Visibles
+	signer *ArtifactSigner
+	quotas mcc.Quotas
+	ledger *quotaLedger
+	// Pending analyses older than this are considered lost
+	jobTimeout time.Duration
+	policy     *auth.Policy
+	http       mcc.HTTP
+	server     *http.Server
+	events     *eventBroker
+	notifier   webhook.Notifier
+	maxDB      int64
+
+	// Files on disk older than this are from earlier runs
+	started time.Time
+
+	// Sessions whose completion was announced, so late or duplicate
+	// results do not announce it again
+	completedMutex sync.Mutex
+	completed      map[int]bool
 }
 
-func NewCommanderSingle(st *Visibles) *CommanderSingle {
-	c := CommanderSingle{}
+func NewCommanderSingle(st *Visibles, cfg mcc.Commander) *CommanderSingle {
+	c := CommanderSingle{
+		vis:        st,
+		signer:     NewArtifactSigner(artifactSecret(cfg.ArtifactSecret), cfg.ArtifactTTL),
+		quotas:     cfg.Quotas,
+		ledger:     newQuotaLedger(),
+		jobTimeout: durationOr(cfg.JobTimeout, defaultJobTimeout),
+		policy:     loadPolicy(cfg.PolicyFile),
+		http:       cfg.HTTP,
+		events:     newEventBroker(),
+		notifier:   newNotifier(cfg),
+		maxDB:      cfg.MaxDatabaseBytes,
+
+		started:   time.Now(),
+		completed: make(map[int]bool),
+	}
+
+	registerConfiguredControllers(cfg.ControllerRepos)
+
+	metrics.RegisterQueueDepth(func() float64 {
+		return float64(storage.CountPendingJobs())
+	})
+
+	go c.consumeResults()
+	go c.sweepLoop(cfg.Retention)
+	go c.expireLoop()
 
-	setupEndpoints(&c)
+	c.server = newHTTPServer(cfg.HTTP, setupEndpoints(&c))
+	c.server.RegisterOnShutdown(c.events.shutdown)
 
 	return &c
 }
@@ -54,4 +109,6 @@ type Visibles struct {
 	QueryPackStore qpstore.Storage
 	// TODO extra package for ql db storage
 	QLDBStore qldbstore.Storage
+	// nil when the API is unauthenticated
+	Auth auth.Authenticator
 }
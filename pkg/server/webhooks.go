@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+	"mrvacommander/pkg/webhook"
+)
+
+// SessionCompletedPayload is the body of session.completed webhooks.
+type SessionCompletedPayload struct {
+	Event          string                 `json:"event"`
+	SessionID      int                    `json:"session_id"`
+	Status         string                 `json:"status"`
+	Actor          common.Actor           `json:"actor"`
+	ControllerRepo string                 `json:"controller_repo"`
+	Language       string                 `json:"language"`
+	Counts         map[string]int         `json:"counts"`
+	TotalResults   int                    `json:"total_result_count"`
+	Repositories   []WebhookRepoResult    `json:"repositories"`
+	Links          map[string]WebhookLink `json:"_links"`
+}
+
+// RepositoryFailedPayload is the body of repository.failed webhooks.
+type RepositoryFailedPayload struct {
+	Event          string            `json:"event"`
+	SessionID      int               `json:"session_id"`
+	Actor          common.Actor      `json:"actor"`
+	ControllerRepo string            `json:"controller_repo"`
+	Repository     WebhookRepoResult `json:"repository"`
+}
+
+type WebhookRepoResult struct {
+	Repository     string `json:"repository"`
+	AnalysisStatus string `json:"analysis_status"`
+	ResultCount    int    `json:"result_count"`
+	ArtifactURL    string `json:"artifact_url,omitempty"`
+}
+
+type WebhookLink struct {
+	Href string `json:"href"`
+}
+
+func newNotifier(cfg mcc.Commander) webhook.Notifier {
+	subs := []webhook.Subscription{}
+	for _, wh := range cfg.Webhooks {
+		if wh.URL == "" {
+			slog.Error("Ignoring webhook without URL")
+			continue
+		}
+		if wh.Secret == "" {
+			slog.Warn("Webhook has no Secret, its deliveries are not signed", "url", wh.URL)
+		}
+		subs = append(subs, webhook.Subscription{URL: wh.URL, Secret: wh.Secret, Events: wh.Events})
+		slog.Info("Registered webhook", "url", wh.URL, "events", wh.Events)
+	}
+	return webhook.NewDispatcher(subs, cfg.WebhookMaxAttempts)
+}
+
+func (c *CommanderSingle) markCompleted(vaid int) bool {
+	c.completedMutex.Lock()
+	defer c.completedMutex.Unlock()
+	if c.completed[vaid] {
+		return false
+	}
+	c.completed[vaid] = true
+	return true
+}
+
+// forgetCompleted lets the completion of a session be announced again, once
+// it is re-run or deleted.
+func (c *CommanderSingle) forgetCompleted(vaid int) {
+	c.completedMutex.Lock()
+	defer c.completedMutex.Unlock()
+	delete(c.completed, vaid)
+}
+
+// notifyResult sends the webhooks triggered by a result.
+func (c *CommanderSingle) notifyResult(res common.AnalyzeResult) {
+	sn, _ := storage.GetSession(res.QueryPackId)
+	controller := fmt.Sprintf("%s/%s", sn.Owner, sn.ControllerRepo)
+
+	if res.Status != common.StatusSuccess {
+		c.notifier.Notify(webhook.EventRepositoryFailed, RepositoryFailedPayload{
+			Event:          webhook.EventRepositoryFailed,
+			SessionID:      res.QueryPackId,
+			Actor:          sn.Actor,
+			ControllerRepo: controller,
+			Repository:     c.webhookRepoResult(res.QueryPackId, res.NWO),
+		})
+	}
+
+	c.notifyIfComplete(res.QueryPackId)
+}
+
+// notifyIfComplete announces the completion of a session once all its
+// analyses are done.  Sessions answered entirely from the cache, or with
+// nothing to analyze, are complete as soon as they are submitted.
+func (c *CommanderSingle) notifyIfComplete(vaid int) {
+	if storage.IsSessionComplete(vaid) && c.markCompleted(vaid) {
+		sn, _ := storage.GetSession(vaid)
+		c.notifier.Notify(webhook.EventSessionCompleted, c.sessionCompletedPayload(sn))
+	}
+}
+
+func (c *CommanderSingle) sessionCompletedPayload(sn common.Session) SessionCompletedPayload {
+	p := SessionCompletedPayload{
+		Event:          webhook.EventSessionCompleted,
+		SessionID:      sn.ID,
+		Status:         sessionStatus(sn.ID),
+		Actor:          sn.Actor,
+		ControllerRepo: fmt.Sprintf("%s/%s", sn.Owner, sn.ControllerRepo),
+		Language:       sn.Language,
+		Counts:         map[string]int{},
+		Repositories:   []WebhookRepoResult{},
+	}
+	for _, job := range storage.GetJobList(sn.ID) {
+		rr := c.webhookRepoResult(sn.ID, job.NWO)
+		p.Counts[rr.AnalysisStatus]++
+		p.TotalResults += rr.ResultCount
+		p.Repositories = append(p.Repositories, rr)
+	}
+
+	base := fmt.Sprintf("%s/repos/%s/code-scanning/codeql/variant-analyses/%d", c.baseURL(), p.ControllerRepo, sn.ID)
+	p.Links = map[string]WebhookLink{
+		"self":    {base},
+		"sarif":   {base + "/sarif"},
+		"csv":     {base + "/csv"},
+		"summary": {base + "/summary"},
+	}
+	return p
+}
+
+func (c *CommanderSingle) webhookRepoResult(vaid int, nwo common.NameWithOwner) WebhookRepoResult {
+	js := common.JobSpec{JobID: vaid, NameWithOwner: nwo}
+	st := storage.GetStatus(vaid, nwo)
+	rr := WebhookRepoResult{
+		Repository:     fmt.Sprintf("%s/%s", nwo.Owner, nwo.Repo),
+		AnalysisStatus: st.ToExternalString(),
+		ResultCount:    storage.GetResult(js).ResultCount,
+	}
+	if st == common.StatusSuccess {
+		// Signed links expire after the artifact TTL
+		rr.ArtifactURL = c.artifactURL(js)
+	}
+	return rr
+}
+
+// List recent webhook deliveries
+func (c *CommanderSingle) AdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, c.notifier.Deliveries())
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"mrvacommander/pkg/common"
+)
+
+// CachedResult is a stored analysis result that identical reruns can reuse.
+type CachedResult struct {
+	Result      common.AnalyzeResult
+	ArchivePath string
+	CreatedAt   time.Time
+}
+
+// How long a CodeQL CLI version is assumed to be deployed after an agent
+// last reported a result produced with it
+const cliVersionTTL = 24 * time.Hour
+
+var (
+	cache       map[common.CacheKey]CachedResult = make(map[common.CacheKey]CachedResult)
+	cliVersions map[string]time.Time             = make(map[string]time.Time)
+	cliVersion  string
+)
+
+// GetCachedResult returns the cached result for key.  Entries whose archive
+// has disappeared are dropped and reported as a miss.
+func GetCachedResult(key common.CacheKey) (CachedResult, bool) {
+	if !key.Complete() {
+		return CachedResult{}, false
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	cr, ok := cache[key]
+	if !ok {
+		return CachedResult{}, false
+	}
+	if _, err := os.Stat(cr.ArchivePath); err != nil {
+		slog.Info("Dropping cached result without archive", "path", cr.ArchivePath)
+		delete(cache, key)
+		return CachedResult{}, false
+	}
+	return cr, true
+}
+
+func SetCachedResult(key common.CacheKey, cr CachedResult) {
+	if !key.Complete() {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	cache[key] = cr
+}
+
+// FindCachedResult returns the cached result of the query pack with the
+// given hash on the database with the given checksum.  Only results of CLI
+// versions that agents still run are used, the most recently reported
+// version first.
+func FindCachedResult(queryPackHash, dbChecksum string) (CachedResult, bool) {
+	for _, v := range CLIVersions() {
+		key := common.CacheKey{QueryPackHash: queryPackHash, DatabaseChecksum: dbChecksum, CLIVersion: v}
+		if cr, ok := GetCachedResult(key); ok {
+			return cr, true
+		}
+	}
+	return CachedResult{}, false
+}
+
+// GetCLIVersion returns the CodeQL CLI version most recently reported by an
+// agent, or "" if none has reported yet.
+func GetCLIVersion() string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return cliVersion
+}
+
+// SetCLIVersion records that an agent produced a result with CLI version v.
+func SetCLIVersion(v string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	cliVersion = v
+	cliVersions[v] = time.Now()
+}
+
+// CLIVersions returns the CodeQL CLI versions agents reported results for
+// within the last day, the most recently reported first.
+func CLIVersions() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	versions := []string{}
+	for v, seen := range cliVersions {
+		if time.Since(seen) > cliVersionTTL {
+			delete(cliVersions, v)
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return cliVersions[versions[i]].After(cliVersions[versions[j]])
+	})
+	return versions
+}
+
+// LinkResultArchive makes the archive at src available as the result archive
+// of nwo in session vaid.  Archives are hard linked when possible and copied
+// otherwise.
+func LinkResultArchive(src string, nwo common.NameWithOwner, vaid int) error {
+	dst, err := ResultArchivePath(nwo, vaid)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
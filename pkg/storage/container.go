@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// ConnectDB opens the PostgreSQL state database.
+func ConnectDB(s DBSpec) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=UTC",
+		s.Host, s.User, s.Password, s.DBname, s.Port)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to state database: %w", err)
+	}
+	return db, nil
+}
+
+// SetupTables creates or migrates the tables of the state database.
+func (s *StorageContainer) SetupTables() error {
+	if s.DB == nil {
+		return fmt.Errorf("state database not connected")
+	}
+	return s.DB.AutoMigrate(&DBInfo{}, &DBJobs{}, &DBResult{}, &DBStatus{})
+}
+
+// CheckHealth pings the state database.
+func (s *StorageContainer) CheckHealth(ctx context.Context) error {
+	if s.DB == nil {
+		return fmt.Errorf("state database not connected")
+	}
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
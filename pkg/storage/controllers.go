@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+
+	"mrvacommander/pkg/common"
+)
+
+var controllers map[int]common.Controller = make(map[int]common.Controller)
+
+func SetController(cr common.Controller) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	controllers[cr.ID] = cr
+}
+
+func GetController(id int) (common.Controller, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	cr, ok := controllers[id]
+	return cr, ok
+}
+
+// FindController looks up a controller by owner and name, ignoring case as
+// GitHub does.
+func FindController(owner, name string) (common.Controller, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, cr := range controllers {
+		if strings.EqualFold(cr.Owner, owner) && strings.EqualFold(cr.Name, name) {
+			return cr, true
+		}
+	}
+	return common.Controller{}, false
+}
+
+func RemoveController(id int) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, ok := controllers[id]
+	delete(controllers, id)
+	return ok
+}
+
+// ListControllers returns all controllers ordered by id.
+func ListControllers() []common.Controller {
+	mutex.Lock()
+	defer mutex.Unlock()
+	list := make([]common.Controller, 0, len(controllers))
+	for _, cr := range controllers {
+		list = append(list, cr)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
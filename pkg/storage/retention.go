@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"mrvacommander/pkg/common"
+)
+
+// SessionFiles returns the query pack and result archives of a session that
+// exist on disk.
+func SessionFiles(sessionid int) []string {
+	files := []string{}
+	if sn, ok := GetSession(sessionid); ok && sn.QueryPack != "" {
+		files = append(files, sn.QueryPack)
+	}
+	for _, job := range GetJobList(sessionid) {
+		if zpath, err := ResultArchivePath(job.NWO, sessionid); err == nil {
+			files = append(files, zpath)
+		}
+	}
+
+	existing := []string{}
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	return existing
+}
+
+// SessionSize returns the bytes on disk used by a session.
+func SessionSize(sessionid int) int64 {
+	var size int64
+	for _, f := range SessionFiles(sessionid) {
+		if fi, err := os.Stat(f); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+// StoredFile is a query pack or result archive on disk.
+type StoredFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// LeftoverFiles returns the query packs and result archives that belong to
+// no known session and were last modified before cutoff, oldest first.
+// They are left from earlier runs of the server, whose sessions are gone.
+func LeftoverFiles(cutoff time.Time) ([]StoredFile, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, sn := range ListSessions(func(common.Session) bool { return true }) {
+		for _, f := range SessionFiles(sn.ID) {
+			known[f] = true
+		}
+	}
+
+	files := []StoredFile{}
+	for _, dir := range []string{path.Join(cwd, "var", "codeql", "querypacks"), resultsDir(cwd)} {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			p := path.Join(dir, e.Name())
+			if !e.Type().IsRegular() || known[p] {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil || !fi.ModTime().Before(cutoff) {
+				continue
+			}
+			files = append(files, StoredFile{Path: p, Size: fi.Size(), ModTime: fi.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime.Before(files[j].ModTime) })
+	return files, nil
+}
+
+// DeleteSession removes a session with its jobs, statuses, results, cached
+// results, query pack and result archives.  Files that cannot be removed
+// are reported, but the session is forgotten regardless.
+func DeleteSession(sessionid int) error {
+	files := SessionFiles(sessionid)
+
+	var errs []error
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, job := range jobs[sessionid] {
+		js := common.JobSpec{JobID: sessionid, NameWithOwner: job.NWO}
+		delete(info, js)
+		delete(status, js)
+		delete(statusAt, js)
+		delete(result, js)
+	}
+	delete(jobs, sessionid)
+	delete(sessions, sessionid)
+
+	removed := make(map[string]bool, len(files))
+	for _, f := range files {
+		removed[f] = true
+	}
+	for key, cr := range cache {
+		if removed[cr.ArchivePath] {
+			delete(cache, key)
+		}
+	}
+
+	slog.Info("Deleted session", "id", sessionid, "files", len(files))
+	return errors.Join(errs...)
+}
@@ -1,25 +1,28 @@
 package storage
 
 import (
-	"archive/zip"
-	"errors"
+	"context"
 	"fmt"
-	"io/fs"
 	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"mrvacommander/pkg/common"
 )
 
 var (
-	jobs   map[int][]common.AnalyzeJob             = make(map[int][]common.AnalyzeJob)
-	info   map[common.JobSpec]common.JobInfo       = make(map[common.JobSpec]common.JobInfo)
-	status map[common.JobSpec]common.Status        = make(map[common.JobSpec]common.Status)
-	result map[common.JobSpec]common.AnalyzeResult = make(map[common.JobSpec]common.AnalyzeResult)
-	mutex  sync.Mutex
+	jobs   map[int][]common.AnalyzeJob       = make(map[int][]common.AnalyzeJob)
+	info   map[common.JobSpec]common.JobInfo = make(map[common.JobSpec]common.JobInfo)
+	status map[common.JobSpec]common.Status  = make(map[common.JobSpec]common.Status)
+	// When each status was set
+	statusAt map[common.JobSpec]time.Time            = make(map[common.JobSpec]time.Time)
+	result   map[common.JobSpec]common.AnalyzeResult = make(map[common.JobSpec]common.AnalyzeResult)
+	sessions map[int]common.Session                  = make(map[int]common.Session)
+	mutex    sync.Mutex
 )
 
 func NewStorageSingle(startingID int, v *Visibles) *StorageSingle {
@@ -31,6 +34,8 @@ func NewStorageSingle(startingID int, v *Visibles) *StorageSingle {
 }
 
 func (s *StorageSingle) NextID() int {
+	mutex.Lock()
+	defer mutex.Unlock()
 	s.currentID += 1
 	return s.currentID
 }
@@ -62,140 +67,188 @@ func (s *StorageSingle) SaveQueryPack(tgz []byte, sessionId int) (string, error)
 	return fpath, nil
 }
 
-//		Determine for which repositories codeql databases are available.
-//
-//	 Those will be the analysis_repos.  The rest will be skipped.
-func (s *StorageSingle) FindAvailableDBs(analysisReposRequested []common.NameWithOwner) (not_found_repos []common.NameWithOwner,
-	analysisRepos *map[common.NameWithOwner]DBLocation) {
-	slog.Debug("Looking for available CodeQL databases")
+func GetResult(js common.JobSpec) common.AnalyzeResult {
+	mutex.Lock()
+	defer mutex.Unlock()
+	ar := result[js]
+	return ar
+}
 
-	cwd, err := os.Getwd()
+func SetResult(sessionid int, nwo common.NameWithOwner, ar common.AnalyzeResult) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	result[common.JobSpec{JobID: sessionid, NameWithOwner: nwo}] = ar
+}
+
+// SaveResultArchive stores the result archive an agent produced for one
+// repository of a session at ResultArchivePath.  The archive is written to
+// a temporary file and renamed, so downloads never see a partial archive.
+func SaveResultArchive(owre common.NameWithOwner, vaid int, data []byte) (string, error) {
+	zpath, err := ResultArchivePath(owre, vaid)
 	if err != nil {
-		slog.Error("No working directory")
-		return
+		return "", err
 	}
 
-	analysisRepos = &map[common.NameWithOwner]DBLocation{}
-
-	not_found_repos = []common.NameWithOwner{}
-
-	for _, rep := range analysisReposRequested {
-		dbPrefix := filepath.Join(cwd, "codeql", "dbs", rep.Owner, rep.Repo)
-		dbName := fmt.Sprintf("%s_%s_db.zip", rep.Owner, rep.Repo)
-		dbPath := filepath.Join(dbPrefix, dbName)
-
-		if _, err := os.Stat(dbPath); errors.Is(err, fs.ErrNotExist) {
-			slog.Info("Database does not exist for repository ", "owner/repo", rep,
-				"path", dbPath)
-			not_found_repos = append(not_found_repos, rep)
-		} else {
-			slog.Info("Found database for ", "owner/repo", rep, "path", dbPath)
-			(*analysisRepos)[rep] = DBLocation{Prefix: dbPrefix, File: dbName}
-		}
+	// Ensure the output directory exists
+	dirpath := filepath.Dir(zpath)
+	if err := os.MkdirAll(dirpath, 0755); err != nil {
+		slog.Error("Unable to create results output directory",
+			"dir", dirpath)
+		return "", err
 	}
-	return not_found_repos, analysisRepos
-}
-
-func ArtifactURL(js common.JobSpec, vaid int) (string, error) {
-	// We're looking for paths like
-	// codeql/sarif/google/flatbuffers/google_flatbuffers.sarif
 
-	ar := GetResult(js)
-
-	hostname, err := os.Hostname()
+	tmp, err := os.CreateTemp(dirpath, ".results-*.zip")
 	if err != nil {
-		slog.Error("No host name found")
-		return "", nil
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), zpath); err != nil {
+		return "", err
 	}
+	return zpath, nil
+}
 
-	zfpath, err := PackageResults(ar, js.NameWithOwner, vaid)
+// ResultArchivePath returns the location of the packaged result archive
+// for one repository of a session.  The archive may not exist yet.
+func ResultArchivePath(owre common.NameWithOwner, vaid int) (string, error) {
+	cwd, err := os.Getwd()
 	if err != nil {
-		slog.Error("Error packaging results:", "error", err)
+		slog.Error("No working directory")
 		return "", err
 	}
-	// TODO Need url valid in container network and externally
-	// For now, we assume the container port 8080 is port 8080 on user's machine
-	hostname = "localhost"
-	au := fmt.Sprintf("http://%s:8080/download-server/%s", hostname, zfpath)
-	return au, nil
+	return path.Join(resultsDir(cwd), resultArchiveName(owre, vaid)), nil
 }
 
-func GetResult(js common.JobSpec) common.AnalyzeResult {
+func resultsDir(cwd string) string {
+	return path.Join(cwd, "var", "codeql", "localrun", "results")
+}
+
+func resultArchiveName(owre common.NameWithOwner, vaid int) string {
+	return fmt.Sprintf("results-%s-%s-%d.zip", owre.Owner, owre.Repo, vaid)
+}
+
+func GetJobList(sessionid int) []common.AnalyzeJob {
 	mutex.Lock()
 	defer mutex.Unlock()
-	ar := result[js]
-	return ar
+	return jobs[sessionid]
 }
 
-func SetResult(sessionid int, nwo common.NameWithOwner, ar common.AnalyzeResult) {
+func GetSession(sessionid int) (common.Session, bool) {
 	mutex.Lock()
 	defer mutex.Unlock()
-	result[common.JobSpec{JobID: sessionid, NameWithOwner: nwo}] = ar
+	s, ok := sessions[sessionid]
+	return s, ok
 }
 
-func PackageResults(ar common.AnalyzeResult, owre common.NameWithOwner, vaid int) (zipPath string, e error) {
-	slog.Debug("Readying zip file with .sarif/.bqrs", "analyze-result", ar)
+func SetSession(s common.Session) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sessions[s.ID] = s
+}
 
-	cwd, err := os.Getwd()
-	if err != nil {
-		slog.Error("No working directory")
-		panic(err)
+// ListSessions returns the sessions for which match is true, newest first.
+func ListSessions(match func(common.Session) bool) []common.Session {
+	mutex.Lock()
+	list := []common.Session{}
+	for _, s := range sessions {
+		list = append(list, s)
 	}
+	mutex.Unlock()
 
-	// Ensure the output directory exists
-	dirpath := path.Join(cwd, "var", "codeql", "localrun", "results")
-	if err := os.MkdirAll(dirpath, 0755); err != nil {
-		slog.Error("Unable to create results output directory",
-			"dir", dirpath)
-		return "", err
+	// match may call back into storage, so run it unlocked
+	out := []common.Session{}
+	for _, s := range list {
+		if match(s) {
+			out = append(out, s)
+		}
 	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out
+}
 
-	// Create a new zip file
-	zpath := path.Join(dirpath, fmt.Sprintf("results-%s-%s-%d.zip", owre.Owner, owre.Repo, vaid))
+// SessionsByActor returns the sessions submitted by the actor with the given
+// login.
+func SessionsByActor(login string) []common.Session {
+	mutex.Lock()
+	defer mutex.Unlock()
+	list := []common.Session{}
+	for _, s := range sessions {
+		if s.Actor.Login == login {
+			list = append(list, s)
+		}
+	}
+	return list
+}
 
-	zfile, err := os.Create(zpath)
-	if err != nil {
-		return "", err
+// IsSessionComplete reports whether every job of a session has reached a
+// final status.
+func IsSessionComplete(sessionid int) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, job := range jobs[sessionid] {
+		switch status[common.JobSpec{JobID: sessionid, NameWithOwner: job.NWO}] {
+		case common.StatusSuccess, common.StatusError, common.StatusFailed:
+		default:
+			return false
+		}
 	}
-	defer zfile.Close()
-
-	// Create a new zip writer
-	zwriter := zip.NewWriter(zfile)
-	defer zwriter.Close()
-
-	// Add each result file to the zip archive
-	/*
-		names := []([]string){{ar.RunAnalysisSARIF, "results.sarif"}}
-		for _, fpath := range names {
-			file, err := os.Open(fpath[0])
-			if err != nil {
-				return "", err
-			}
-			defer file.Close()
-
-			// Create a new file in the zip archive with custom name
-			// The client is very specific:
-			// if zf.Name != "results.sarif" && zf.Name != "results.bqrs" { continue }
-
-			zipEntry, err := zwriter.Create(fpath[1])
-			if err != nil {
-				return "", err
-			}
-
-			// Copy the contents of the file to the zip entry
-			_, err = io.Copy(zipEntry, file)
-			if err != nil {
-				return "", err
-			}
+	return true
+}
+
+// IsSessionActive reports whether a session has a job queued or in progress
+// whose status was set after since.  Jobs without a status count as
+// recent.
+func IsSessionActive(sessionid int, since time.Time) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, job := range jobs[sessionid] {
+		js := common.JobSpec{JobID: sessionid, NameWithOwner: job.NWO}
+		switch status[js] {
+		case common.StatusSuccess, common.StatusError, common.StatusFailed:
+			continue
 		}
-	*/
-	return zpath, nil
+		if at, ok := statusAt[js]; !ok || at.After(since) {
+			return true
+		}
+	}
+	return false
 }
 
-func GetJobList(sessionid int) []common.AnalyzeJob {
+// StalledJobs returns the jobs queued or in progress whose status was set
+// before cutoff.
+func StalledJobs(cutoff time.Time) []common.JobSpec {
 	mutex.Lock()
 	defer mutex.Unlock()
-	return jobs[sessionid]
+	stalled := []common.JobSpec{}
+	for js, st := range status {
+		if st != common.StatusQueued && st != common.StatusInProgress {
+			continue
+		}
+		if at, ok := statusAt[js]; ok && at.Before(cutoff) {
+			stalled = append(stalled, js)
+		}
+	}
+	return stalled
+}
+
+// CountPendingJobs returns the number of jobs queued or in progress.
+func CountPendingJobs() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+	n := 0
+	for _, st := range status {
+		if st == common.StatusQueued || st == common.StatusInProgress {
+			n++
+		}
+	}
+	return n
 }
 
 func GetJobInfo(js common.JobSpec) common.JobInfo {
@@ -216,29 +269,45 @@ func GetStatus(sessionid int, nwo common.NameWithOwner) common.Status {
 	return status[common.JobSpec{JobID: sessionid, NameWithOwner: nwo}]
 }
 
-func ResultAsFile(path string) (string, []byte, error) {
-	fpath := path
-	if !filepath.IsAbs(path) {
-		fpath = "/" + path
-	}
-
-	file, err := os.ReadFile(fpath)
-	if err != nil {
-		slog.Warn("Failed to read results file", fpath, err)
-		return "", nil, err
-	}
-
-	return fpath, file, nil
-}
-
 func SetStatus(sessionid int, nwo common.NameWithOwner, s common.Status) {
 	mutex.Lock()
 	defer mutex.Unlock()
-	status[common.JobSpec{JobID: sessionid, NameWithOwner: nwo}] = s
+	js := common.JobSpec{JobID: sessionid, NameWithOwner: nwo}
+	status[js] = s
+	statusAt[js] = time.Now()
 }
 
+// AddJob records a job of a session, replacing an earlier job for the same
+// repository, as when it is retried.
 func AddJob(sessionid int, job common.AnalyzeJob) {
 	mutex.Lock()
 	defer mutex.Unlock()
+	for i, j := range jobs[sessionid] {
+		if j.NWO == job.NWO {
+			jobs[sessionid][i] = job
+			return
+		}
+	}
 	jobs[sessionid] = append(jobs[sessionid], job)
 }
+
+// CheckHealth verifies that query packs and result archives can be
+// written.  Session state is kept in memory.
+func (s *StorageSingle) CheckHealth(ctx context.Context) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{path.Join(cwd, "var", "codeql", "querypacks"), resultsDir(cwd)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("cannot create %s: %w", dir, err)
+		}
+		f, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return fmt.Errorf("cannot write to %s: %w", dir, err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return nil
+}
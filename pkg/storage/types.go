@@ -2,14 +2,14 @@ package storage
 
 import (
 	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/qldbstore"
 
 	"gorm.io/gorm"
 )
 
-type DBLocation struct {
-	Prefix string
-	File   string
-}
+// DBLocation is where the database of a repository is found; it is defined
+// by the database store.
+type DBLocation = qldbstore.DBLocation
 
 type StorageSingle struct {
 	currentID int
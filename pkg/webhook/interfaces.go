@@ -0,0 +1,7 @@
+package webhook
+
+// Notifier delivers events to the subscribed webhooks.
+type Notifier interface {
+	Notify(event string, payload interface{})
+	Deliveries() []Delivery
+}
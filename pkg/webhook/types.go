@@ -0,0 +1,49 @@
+package webhook
+
+import "time"
+
+// Events
+const (
+	EventSessionCompleted = "session.completed"
+	EventRepositoryFailed = "repository.failed"
+)
+
+// Request headers of a delivery, modelled on GitHub's
+const (
+	HeaderEvent     = "X-MRVA-Event"
+	HeaderDelivery  = "X-MRVA-Delivery"
+	HeaderSignature = "X-MRVA-Signature-256"
+)
+
+type Subscription struct {
+	URL string
+	// Key of the HMAC-SHA256 signature in HeaderSignature; unsigned if empty
+	Secret string
+	// Events to deliver; all if empty
+	Events []string
+}
+
+func (s Subscription) wants(event string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is an entry of the delivery log.
+type Delivery struct {
+	ID            string    `json:"id"`
+	Event         string    `json:"event"`
+	URL           string    `json:"url"`
+	Delivered     bool      `json:"delivered"`
+	Attempts      int       `json:"attempts"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at,omitempty"`
+}
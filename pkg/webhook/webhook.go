@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMaxAttempts = 5
+	firstRetryDelay    = time.Second
+	requestTimeout     = 10 * time.Second
+	deliveryLogSize    = 200
+)
+
+// Dispatcher posts events to webhook subscriptions in the background,
+// retrying failed deliveries with exponential backoff, and keeps a log of
+// recent deliveries.
+type Dispatcher struct {
+	subs        []Subscription
+	client      *http.Client
+	maxAttempts int
+	retryDelay  time.Duration
+
+	mutex sync.Mutex
+	log   []*Delivery
+}
+
+// NewDispatcher returns a dispatcher for subs.  maxAttempts of 0 uses the
+// default of 5.
+func NewDispatcher(subs []Subscription, maxAttempts int) *Dispatcher {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	return &Dispatcher{
+		subs:        subs,
+		client:      &http.Client{Timeout: requestTimeout},
+		maxAttempts: maxAttempts,
+		retryDelay:  firstRetryDelay,
+	}
+}
+
+// Sign returns the signature header value of body under secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify queues the delivery of payload to every subscription of event.
+func (d *Dispatcher) Notify(event string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error encoding webhook payload as JSON", "event", event, "error", err)
+		return
+	}
+	for _, s := range d.subs {
+		if !s.wants(event) {
+			continue
+		}
+		dl := d.record(Delivery{
+			ID:        uuid.New().String(),
+			Event:     event,
+			URL:       s.URL,
+			CreatedAt: time.Now(),
+		})
+		go d.deliver(s, dl, body)
+	}
+}
+
+// Deliveries returns the delivery log, most recent first.
+func (d *Dispatcher) Deliveries() []Delivery {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	out := make([]Delivery, 0, len(d.log))
+	for i := len(d.log) - 1; i >= 0; i-- {
+		out = append(out, *d.log[i])
+	}
+	return out
+}
+
+func (d *Dispatcher) record(dl Delivery) *Delivery {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.log) == deliveryLogSize {
+		d.log = d.log[1:]
+	}
+	d.log = append(d.log, &dl)
+	return &dl
+}
+
+func (d *Dispatcher) update(dl *Delivery, f func(*Delivery)) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	f(dl)
+}
+
+func (d *Dispatcher) deliver(s Subscription, dl *Delivery, body []byte) {
+	delay := d.retryDelay
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		code, err := d.post(s, dl, body)
+		d.update(dl, func(dl *Delivery) {
+			dl.Attempts = attempt
+			dl.StatusCode = code
+			dl.LastAttemptAt = time.Now()
+			dl.Delivered = err == nil
+			dl.Error = ""
+			if err != nil {
+				dl.Error = err.Error()
+			}
+		})
+		if err == nil {
+			slog.Info("Webhook delivered", "event", dl.Event, "url", s.URL, "delivery", dl.ID)
+			return
+		}
+		if !retryable(code) {
+			break
+		}
+		slog.Warn("Webhook delivery failed, retrying", "event", dl.Event, "url", s.URL,
+			"attempt", attempt, "error", err)
+		if attempt < d.maxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	slog.Error("Webhook delivery failed", "event", dl.Event, "url", s.URL, "delivery", dl.ID)
+}
+
+func (d *Dispatcher) post(s Subscription, dl *Delivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mrvacommander-webhook")
+	req.Header.Set(HeaderEvent, dl.Event)
+	req.Header.Set(HeaderDelivery, dl.ID)
+	if s.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(s.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Network errors, server errors and rate limiting are worth retrying;
+// other client errors will not go away.
+func retryable(code int) bool {
+	return code == 0 || code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mrvacommander/pkg/agent"
+	"mrvacommander/pkg/codeql"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/queue"
+	"mrvacommander/pkg/storage"
+)
+
+type fakeQueue struct {
+	err error
+}
+
+func (q *fakeQueue) Jobs() chan common.AnalyzeJob       { return nil }
+func (q *fakeQueue) Results() chan common.AnalyzeResult { return nil }
+func (q *fakeQueue) StartAnalyses(*map[common.NameWithOwner]storage.DBLocation, int, string, string) {
+}
+func (q *fakeQueue) CheckHealth(ctx context.Context) error { return q.err }
+
+func TestAgentStatusHandler(t *testing.T) {
+	q := &fakeQueue{}
+	h := agent.StatusHandler(q)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz = %d with a healthy queue", rec.Code)
+	}
+
+	q.err = errors.New("connection closed")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("healthz = %d with a broken queue", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var st agent.Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("status is not JSON: %v", err)
+	}
+	if st.Version.Version == "" || st.CurrentJobs == nil {
+		t.Errorf("status = %+v", st)
+	}
+}
+
+func TestCLIVersionBackoff(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	cli := filepath.Join(dir, "codeql")
+	script := "#!/bin/sh\necho run >> " + calls + "\nexit 1\n"
+	if err := os.WriteFile(cli, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CODEQL_CLI_PATH", cli)
+
+	// A failing CLI is not asked again on every status request
+	for i := 0; i < 3; i++ {
+		if _, err := codeql.GetCLIVersion(); err == nil {
+			t.Fatal("failing CLI reported a version")
+		}
+		agent.GetStatus()
+	}
+	buf, _ := os.ReadFile(calls)
+	if n := strings.Count(string(buf), "run"); n > 1 {
+		t.Errorf("CLI run %d times", n)
+	}
+}
+
+func TestWorkerReportsFailedJobs(t *testing.T) {
+	inTempDir(t)
+	q := queue.NewQueueSingle(1, &queue.Visibles{})
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "broken"}
+	q.Jobs() <- common.AnalyzeJob{QueryPackId: 990501, NWO: nwo,
+		DatabasePath: filepath.Join(t.TempDir(), "missing_db.zip")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go agent.RunWorker(ctx, make(chan struct{}), q, &wg)
+
+	select {
+	case res := <-q.Results():
+		if res.Status != common.StatusError || res.QueryPackId != 990501 || res.NWO != nwo {
+			t.Errorf("result %+v", res)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("failed job not reported")
+	}
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/server"
+)
+
+func TestAPIErrorWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	server.NewAPIError(http.StatusUnprocessableEntity, "Validation Failed", server.APIErrorDetail{
+		Resource: "VariantAnalysis", Field: "repositories", Code: server.ErrCodeInvalid,
+	}).Write(rec)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("content type = %q", ct)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if body["message"] != "Validation Failed" || body["documentation_url"] == "" {
+		t.Errorf("body = %v", body)
+	}
+	errs, _ := body["errors"].([]interface{})
+	if len(errs) != 1 || errs[0].(map[string]interface{})["field"] != "repositories" {
+		t.Errorf("errors = %v", body["errors"])
+	}
+
+	rec = httptest.NewRecorder()
+	server.NewAPIError(http.StatusNotFound, "").Write(rec)
+	body = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["message"] != "Not Found" {
+		t.Errorf("default message = %v", body["message"])
+	}
+	if _, ok := body["errors"]; ok {
+		t.Error("empty errors list should be omitted")
+	}
+}
+
+func TestSubmitRejectsPathNames(t *testing.T) {
+	c, _ := newTestCommander(t, mcc.Commander{}, loginTokens{})
+	registerQuotaController()
+
+	for _, repo := range []string{"../..", "octo/..", "./lib", "octo/a b"} {
+		rec := submit(t, c, "alice", repo)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: status %d, want 422", repo, rec.Code)
+		}
+	}
+}
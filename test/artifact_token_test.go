@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/server"
+)
+
+func TestArtifactToken(t *testing.T) {
+	signer := server.NewArtifactSigner([]byte("secret"), time.Hour)
+	js := common.JobSpec{
+		JobID:         54674,
+		NameWithOwner: common.NameWithOwner{Owner: "google", Repo: "flatbuffers"},
+	}
+
+	token := signer.Sign(js)
+	if strings.ContainsAny(token, "/") {
+		t.Errorf("token %q is not a single path segment", token)
+	}
+
+	got, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got != js {
+		t.Errorf("Verify = %+v, want %+v", got, js)
+	}
+
+	other := server.NewArtifactSigner([]byte("other"), time.Hour)
+	if _, err := other.Verify(token); !errors.Is(err, server.ErrInvalidArtifactToken) {
+		t.Errorf("token verified with a different secret: %v", err)
+	}
+
+	payload, mac, _ := strings.Cut(token, ".")
+	tampered := strings.ToUpper(payload[:1]) + strings.ToLower(payload[1:]) + "." + mac
+	for _, bad := range []string{"", "etc/passwd", tampered, payload + "." + payload} {
+		if _, err := signer.Verify(bad); !errors.Is(err, server.ErrInvalidArtifactToken) {
+			t.Errorf("Verify(%q) = %v, want invalid token", bad, err)
+		}
+	}
+}
+
+func TestArtifactTokenExpiry(t *testing.T) {
+	signer := server.NewArtifactSigner([]byte("secret"), time.Nanosecond)
+	token := signer.Sign(common.JobSpec{JobID: 1})
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := signer.Verify(token); !errors.Is(err, server.ErrExpiredArtifactToken) {
+		t.Errorf("Verify after expiry = %v, want expired", err)
+	}
+}
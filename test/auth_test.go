@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+)
+
+func signJWT(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	hdr, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing := enc.EncodeToString(hdr) + "." + enc.EncodeToString(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signing))
+	return signing + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTVerifier(t *testing.T) {
+	v := auth.NewJWTVerifier([]byte("s3cret"), "mrva")
+	exp := time.Now().Add(time.Hour).Unix()
+
+	id, err := v.Authenticate(signJWT(t, "s3cret", map[string]interface{}{
+		"sub": "alice", "iss": "mrva", "exp": exp, "uid": 7, "teams": []string{"lab"},
+	}))
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if id.Login != "alice" || id.ID != 7 || id.Admin || len(id.Teams) != 1 {
+		t.Errorf("identity = %+v", id)
+	}
+
+	cases := map[string]string{
+		"wrong secret": signJWT(t, "other", map[string]interface{}{"sub": "alice", "iss": "mrva", "exp": exp}),
+		"wrong issuer": signJWT(t, "s3cret", map[string]interface{}{"sub": "alice", "iss": "x", "exp": exp}),
+		"no subject":   signJWT(t, "s3cret", map[string]interface{}{"iss": "mrva", "exp": exp}),
+		"garbage":      "not.a.jwt",
+	}
+	for name, token := range cases {
+		if _, err := v.Authenticate(token); !errors.Is(err, auth.ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want invalid token", name, err)
+		}
+	}
+
+	expired := signJWT(t, "s3cret", map[string]interface{}{"sub": "alice", "iss": "mrva", "exp": 1})
+	if _, err := v.Authenticate(expired); !errors.Is(err, auth.ErrExpiredToken) {
+		t.Errorf("expired: err = %v", err)
+	}
+}
+
+func TestStaticTokens(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "tokens.toml")
+	err := os.WriteFile(fname, []byte(`
+[[token]]
+token = "plain"
+login = "alice"
+id = 1
+
+[[token]]
+# sha256 of "hashed"
+sha256 = "1a06df824ed741b53c785079a6347f00eec5af82f9850775409ca69dff4068a6"
+login = "bob"
+admin = true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := auth.New(fname, "", "")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	id, err := a.Authenticate("plain")
+	if err != nil || id.Login != "alice" {
+		t.Errorf("plain token: %+v, %v", id, err)
+	}
+	if id, err := a.Authenticate("hashed"); err != nil || id.Login != "bob" || !id.Admin {
+		t.Errorf("hashed token: %+v, %v", id, err)
+	}
+	if _, err := a.Authenticate("nope"); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("unknown token: %v", err)
+	}
+
+	owner := common.Actor{Login: "alice"}
+	if !id.CanAccess(owner) {
+		t.Error("actor cannot access own session")
+	}
+	if (auth.Identity{Login: "mallory"}).CanAccess(owner) {
+		t.Error("other actor can access session")
+	}
+	if !(auth.Identity{Login: "root", Admin: true}).CanAccess(owner) {
+		t.Error("admin cannot access session")
+	}
+
+	if a, err := auth.New("", "", ""); a != nil || err != nil {
+		t.Errorf("unconfigured New = %v, %v; want nil, nil", a, err)
+	}
+}
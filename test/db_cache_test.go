@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"mrvacommander/pkg/agent"
+	"mrvacommander/utils"
+)
+
+// writeArchive writes a database archive of about size bytes to dir.
+func writeArchive(t *testing.T, dir, name string, size int) string {
+	t.Helper()
+	buf := zipBytes(t, map[string]string{
+		"db/codeql-database.yml":   "primaryLanguage: go\n",
+		"db/db-go/default/" + name: strings.Repeat("x", size),
+	})
+	fname := filepath.Join(dir, name+".zip")
+	if err := os.WriteFile(fname, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestDBCacheReuse(t *testing.T) {
+	src := t.TempDir()
+	cacheDir := t.TempDir()
+	archive := writeArchive(t, src, "a", 1000)
+
+	c, err := agent.NewDBCache(cacheDir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := c.Acquire(archive, "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer db.Release()
+			if _, err := os.Stat(filepath.Join(db.Path, "codeql-database.yml")); err != nil {
+				t.Error(err)
+			}
+			// Results of an analysis are cleared for the next one
+			os.MkdirAll(filepath.Join(db.Path, "results", "stale"), 0755)
+		}()
+	}
+	wg.Wait()
+
+	st := c.Stats()
+	if st.Misses != 1 || st.Hits != 3 || st.Entries != 1 || st.InUse != 0 {
+		t.Errorf("stats %+v", st)
+	}
+
+	// Entries survive a restart
+	c2, err := agent.NewDBCache(cacheDir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, _ := utils.FileChecksum(archive)
+	db, err := c2.Acquire(archive, sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(db.Path, "results")); !os.IsNotExist(err) {
+		t.Errorf("stale results kept: %v", err)
+	}
+	db.Release()
+	if st := c2.Stats(); st.Hits != 1 || st.Misses != 0 {
+		t.Errorf("stats after restart %+v", st)
+	}
+}
+
+func TestDBCacheIntegrity(t *testing.T) {
+	archive := writeArchive(t, t.TempDir(), "a", 10)
+	c, err := agent.NewDBCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Acquire(archive, strings.Repeat("0", 64)); err == nil {
+		t.Error("archive with the wrong checksum was accepted")
+	}
+	if st := c.Stats(); st.Entries != 0 || st.Bytes != 0 {
+		t.Errorf("failed extraction kept: %+v", st)
+	}
+
+	// A damaged entry is extracted again
+	db, err := c.Acquire(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := db.Path
+	db.Release()
+	if err := os.Remove(filepath.Join(path, "codeql-database.yml")); err != nil {
+		t.Fatal(err)
+	}
+	db, err = c.Acquire(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Release()
+	if _, err := os.Stat(filepath.Join(db.Path, "codeql-database.yml")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDBCacheEviction(t *testing.T) {
+	src := t.TempDir()
+	a := writeArchive(t, src, "a", 6000)
+	b := writeArchive(t, src, "b", 6000)
+	c, err := agent.NewDBCache(t.TempDir(), 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbA, err := c.Acquire(a, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a is in use, so the cache exceeds its budget rather than evict it
+	dbB, err := c.Acquire(b, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.Entries != 2 || st.Evictions != 0 {
+		t.Errorf("in-use entry evicted: %+v", st)
+	}
+	if _, err := os.Stat(dbA.Path); err != nil {
+		t.Error(err)
+	}
+
+	// Releasing a, the least recently used, evicts it
+	dbA.Release()
+	st := c.Stats()
+	if st.Entries != 1 || st.Evictions != 1 || st.Bytes > 10000 {
+		t.Errorf("after release %+v", st)
+	}
+	if _, err := os.Stat(dbA.Path); !os.IsNotExist(err) {
+		t.Errorf("evicted database still on disk: %v", err)
+	}
+	dbB.Release()
+}
+
+func TestDBCacheKeepsUnrelatedFiles(t *testing.T) {
+	archive := writeArchive(t, t.TempDir(), "a", 10)
+	dir := t.TempDir()
+	c, err := agent.NewDBCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := c.Acquire(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Release()
+
+	partial := filepath.Join(dir, strings.Repeat("ab", 32))
+	for _, d := range []string{filepath.Join(dir, "notes"), filepath.Join(dir, ".tmp-123"), partial} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("shared directory\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart keeps the complete entry and whatever else is in the
+	// directory, and removes only partial extractions
+	c, err = agent.NewDBCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.Entries != 1 {
+		t.Errorf("entries after restart: %+v", st)
+	}
+	for _, kept := range []string{"notes", "README"} {
+		if _, err := os.Stat(filepath.Join(dir, kept)); err != nil {
+			t.Errorf("%s removed: %v", kept, err)
+		}
+	}
+	for _, gone := range []string{filepath.Join(dir, ".tmp-123"), partial} {
+		if _, err := os.Stat(gone); !os.IsNotExist(err) {
+			t.Errorf("%s kept: %v", gone, err)
+		}
+	}
+	if db, err := c.Acquire(archive, ""); err != nil {
+		t.Error(err)
+	} else {
+		db.Release()
+	}
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 0 {
+		t.Errorf("cached entry not reused after restart: %+v", st)
+	}
+}
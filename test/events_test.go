@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/server"
+)
+
+// freeAddress returns a local address nothing listens on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// serve runs c on its listen address until the returned stop function is
+// called, which returns the result of Serve.
+func serve(t *testing.T, c *server.CommanderSingle, addr string) func() error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- c.Serve(ctx) }()
+	// A fresh connection per probe, so no half-used connection is left
+	// behind to delay the shutdown.
+	probe := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	waitFor(t, "listener", func() bool {
+		resp, err := probe.Get("http://" + addr + "/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	})
+	stopped := false
+	stop := func() error {
+		stopped = true
+		cancel()
+		return <-errc
+	}
+	t.Cleanup(func() {
+		if !stopped {
+			stop()
+		}
+	})
+	return stop
+}
+
+// eventStream reads Server-Sent Events.
+type eventStream struct {
+	resp *http.Response
+	sc   *bufio.Scanner
+}
+
+// openEvents opens the event stream of session vaid as login.
+func openEvents(t *testing.T, base, login string, vaid int) *eventStream {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet,
+		base+"/repos/octo/ctl/code-scanning/codeql/variant-analyses/"+strconv.Itoa(vaid)+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+login)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("event stream: %s", resp.Status)
+	}
+	return &eventStream{resp: resp, sc: bufio.NewScanner(resp.Body)}
+}
+
+// next returns the name and data of the next event, skipping comments, or
+// false at the end of the stream.
+func (s *eventStream) next() (string, string, bool) {
+	name, data := "", ""
+	for s.sc.Scan() {
+		line := s.sc.Text()
+		switch {
+		case line == "" && name != "":
+			return name, data, true
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return "", "", false
+}
+
+// submitted returns the id of an accepted submission.
+func submitted(t *testing.T, c *server.CommanderSingle, login string, repos ...string) int {
+	t.Helper()
+	rec := submit(t, c, login, repos...)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("submission: %d %s", rec.Code, rec.Body.String())
+	}
+	var sr common.SubmitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &sr); err != nil {
+		t.Fatal(err)
+	}
+	return sr.ID
+}
+
+func TestEventStreamEndsOnShutdown(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	addr := freeAddress(t)
+	c, _ := newTestCommander(t, mcc.Commander{HTTP: mcc.HTTP{ListenAddress: addr, ShutdownTimeout: 10 * time.Second}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+	stop := serve(t, c, addr)
+
+	vaid := submitted(t, c, "alice", names...)
+	stream := openEvents(t, "http://"+addr, "alice", vaid)
+	if name, _, ok := stream.next(); !ok || name != "repository" {
+		t.Fatalf("first event %q", name)
+	}
+
+	start := time.Now()
+	if err := stop(); err != nil {
+		t.Fatalf("shutdown with an open event stream: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("shutdown took %v", d)
+	}
+	if name, _, ok := stream.next(); ok {
+		t.Errorf("event %q after shutdown", name)
+	}
+}
+
+// nextEvent returns the next event of s, which must be called name, and
+// decodes its data into v.
+func nextEvent(t *testing.T, s *eventStream, name string, v interface{}) {
+	t.Helper()
+	got, data, ok := s.next()
+	if !ok {
+		t.Fatalf("stream ended, want %s event", name)
+	}
+	if got != name {
+		t.Fatalf("event %q, want %q: %s", got, name, data)
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		t.Fatalf("%s event: %v", name, err)
+	}
+}
+
+func TestEventStreamDelivery(t *testing.T) {
+	nwos, names := quotaRepos(2)
+	c, q := newTestCommander(t, mcc.Commander{}, loginTokens{}, nwos...)
+	registerQuotaController()
+	ts := httptest.NewServer(c.Handler())
+	defer ts.Close()
+
+	vaid := submitted(t, c, "alice", names...)
+	stream := openEvents(t, ts.URL, "alice", vaid)
+
+	// A snapshot of every repository comes first
+	for range nwos {
+		var ev server.RepoEvent
+		nextEvent(t, stream, "repository", &ev)
+		if ev.SessionID != vaid || ev.AnalysisStatus != "queued" {
+			t.Errorf("snapshot event = %+v", ev)
+		}
+	}
+
+	q.Results() <- common.AnalyzeResult{Status: common.StatusSuccess, QueryPackId: vaid, NWO: nwos[0],
+		ResultCount: 3, ResultArchive: zipBytes(t, map[string]string{"results.sarif": sampleSarif})}
+	var ev server.RepoEvent
+	nextEvent(t, stream, "repository", &ev)
+	if ev.Repository != names[0] || ev.AnalysisStatus != "succeeded" || ev.ResultCount != 3 {
+		t.Errorf("event for the first result = %+v", ev)
+	}
+
+	q.Results() <- common.AnalyzeResult{Status: common.StatusFailed, QueryPackId: vaid, NWO: nwos[1]}
+	nextEvent(t, stream, "repository", &ev)
+	if ev.Repository != names[1] || ev.AnalysisStatus != "failed" {
+		t.Errorf("event for the second result = %+v", ev)
+	}
+
+	// The session event is the last one
+	var se server.SessionEvent
+	nextEvent(t, stream, "session", &se)
+	if se.SessionID != vaid || se.Status != "failed" {
+		t.Errorf("session event = %+v", se)
+	}
+	if name, _, ok := stream.next(); ok {
+		t.Errorf("event %q after the session event", name)
+	}
+
+	// A stream of a finished session replays its state and ends
+	stream = openEvents(t, ts.URL, "alice", vaid)
+	statuses := map[string]string{}
+	for range nwos {
+		nextEvent(t, stream, "repository", &ev)
+		statuses[ev.Repository] = ev.AnalysisStatus
+	}
+	if statuses[names[0]] != "succeeded" || statuses[names[1]] != "failed" {
+		t.Errorf("replayed statuses = %v", statuses)
+	}
+	nextEvent(t, stream, "session", &se)
+	if se.Status != "failed" {
+		t.Errorf("replayed session event = %+v", se)
+	}
+	if name, _, ok := stream.next(); ok {
+		t.Errorf("event %q after the replayed session event", name)
+	}
+}
+
+func TestEventStreamOfOtherActor(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	c, _ := newTestCommander(t, mcc.Commander{}, loginTokens{}, nwos...)
+	registerQuotaController()
+	vaid := submitted(t, c, "alice", names...)
+
+	req := httptest.NewRequest(http.MethodGet,
+		"/repos/octo/ctl/code-scanning/codeql/variant-analyses/"+strconv.Itoa(vaid)+"/events", nil)
+	req.Header.Set("Authorization", "Bearer mallory")
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK {
+		t.Errorf("stream of another actor's session: %d", rec.Code)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mrvacommander/config/mcc"
+)
+
+func TestReadyzStores(t *testing.T) {
+	c, _ := newTestCommander(t, mcc.Commander{}, nil)
+
+	checks := func() map[string]map[string]string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var rd struct {
+			Checks map[string]map[string]string `json:"checks"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &rd); err != nil {
+			t.Fatalf("readyz is not JSON: %v", err)
+		}
+		return rd.Checks
+	}
+
+	got := checks()
+	for _, name := range []string{"server_store", "ql_db_store"} {
+		if got[name]["status"] != "ok" {
+			t.Errorf("%s = %v", name, got[name])
+		}
+	}
+
+	// The database store is checked on disk
+	if err := os.RemoveAll(filepath.Join("codeql", "dbs")); err != nil {
+		t.Fatal(err)
+	}
+	if st := checks()["ql_db_store"]["status"]; st != "unavailable" {
+		t.Errorf("ql_db_store without root = %q", st)
+	}
+}
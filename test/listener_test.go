@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/server"
+)
+
+func TestPublicURL(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		http mcc.HTTP
+		want string
+	}{
+		{"default", mcc.HTTP{}, "http://localhost:8080"},
+		{"configured", mcc.HTTP{PublicURL: "https://mrva.example.com/", ListenAddress: ":9090"}, "https://mrva.example.com"},
+		{"configured with path", mcc.HTTP{PublicURL: "https://example.com/mrva"}, "https://example.com/mrva"},
+		{"any address", mcc.HTTP{ListenAddress: "0.0.0.0:9090"}, "http://localhost:9090"},
+		{"any IPv6 address", mcc.HTTP{ListenAddress: "[::]:9090"}, "http://localhost:9090"},
+		{"port only", mcc.HTTP{ListenAddress: ":9090"}, "http://localhost:9090"},
+		{"host", mcc.HTTP{ListenAddress: "10.1.2.3:9090"}, "http://10.1.2.3:9090"},
+		{"IPv6 host", mcc.HTTP{ListenAddress: "[fd00::1]:9090"}, "http://[fd00::1]:9090"},
+		{"TLS", mcc.HTTP{ListenAddress: ":8443", TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, "https://localhost:8443"},
+		{"TLS without key", mcc.HTTP{ListenAddress: ":8443", TLSCertFile: "cert.pem"}, "http://localhost:8443"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			nwos, names := quotaRepos(1)
+			c, _ := newTestCommander(t, mcc.Commander{HTTP: tc.http}, loginTokens{}, nwos...)
+			registerQuotaController()
+			vaid := submitted(t, c, "alice", names...)
+
+			req := httptest.NewRequest(http.MethodGet, "/variant-analyses", nil)
+			req.Header.Set("Authorization", "Bearer alice")
+			rec := httptest.NewRecorder()
+			c.Handler().ServeHTTP(rec, req)
+			var list server.SessionList
+			if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+				t.Fatalf("%d %s: %v", rec.Code, rec.Body.String(), err)
+			}
+			want := fmt.Sprintf("%s/repos/octo/ctl/code-scanning/codeql/variant-analyses/%d", tc.want, vaid)
+			if len(list.VariantAnalyses) != 1 || list.VariantAnalyses[0].URL != want {
+				t.Errorf("sessions = %+v, want url %s", list.VariantAnalyses, want)
+			}
+		})
+	}
+}
+
+// slowSubmission starts a submission to addr whose body is sent in two
+// parts; the second is sent by calling the returned function, which returns
+// the response.
+func slowSubmission(t *testing.T, addr string, repos ...string) func() (*http.Response, error) {
+	t.Helper()
+	body, err := json.Marshal(common.SubmitMsg{Language: "cpp", QueryPack: queryPack(t), Repositories: repos})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost,
+		"http://"+addr+"/repos/octo/ctl/code-scanning/codeql/variant-analyses", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer alice")
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	type reply struct {
+		resp *http.Response
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		resp, err := client.Do(req)
+		replies <- reply{resp, err}
+	}()
+	half := len(body) / 2
+	if _, err := pw.Write(body[:half]); err != nil {
+		t.Fatal(err)
+	}
+	// Let the server start on the request
+	time.Sleep(100 * time.Millisecond)
+
+	return func() (*http.Response, error) {
+		go func() {
+			io.Copy(pw, bytes.NewReader(body[half:]))
+			pw.Close()
+		}()
+		r := <-replies
+		return r.resp, r.err
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	addr := freeAddress(t)
+	c, _ := newTestCommander(t, mcc.Commander{HTTP: mcc.HTTP{ListenAddress: addr, ShutdownTimeout: 10 * time.Second}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+	stop := serve(t, c, addr)
+
+	finish := slowSubmission(t, addr, names...)
+	stopped := make(chan error, 1)
+	go func() { stopped <- stop() }()
+
+	// The server waits for the request in flight
+	select {
+	case err := <-stopped:
+		t.Fatalf("server stopped with a request in flight: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	resp, err := finish()
+	if err != nil {
+		t.Fatalf("request in flight: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("request in flight: %s", resp.Status)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("graceful shutdown: %v", err)
+	}
+
+	// New connections are refused
+	if resp, err := http.Get("http://" + addr + "/healthz"); err == nil {
+		resp.Body.Close()
+		t.Error("server still accepts connections after shutdown")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	addr := freeAddress(t)
+	c, _ := newTestCommander(t, mcc.Commander{HTTP: mcc.HTTP{ListenAddress: addr, ShutdownTimeout: 100 * time.Millisecond}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+	stop := serve(t, c, addr)
+
+	finish := slowSubmission(t, addr, names...)
+	defer func() {
+		if resp, err := finish(); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	if err := stop(); err == nil {
+		t.Error("shutdown did not report the request it gave up on")
+	}
+}
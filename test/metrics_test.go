@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"mrvacommander/pkg/metrics"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	metrics.ObservePhase(metrics.PhaseRunQueries, time.Now().Add(-2*time.Second))
+	// Other tests queue analyses as well; count one in a language they don't use.
+	metrics.Repositories.WithLabelValues("ruby", metrics.RepoQueued).Inc()
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`mrva_job_phase_duration_seconds_count{phase="run_queries"} 1`,
+		`mrva_repositories_total{language="ruby",status="queued"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output lacks %q", want)
+		}
+	}
+}
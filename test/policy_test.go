@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+)
+
+func TestPolicy(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "policy.toml")
+	err := os.WriteFile(fname, []byte(`
+[[rule]]
+logins = ["alice"]
+repos = ["octo-org"]
+
+[[rule]]
+teams = ["Lab"]
+repos = ["acme/lib-*", "github/codeql"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := auth.LoadPolicyFile(fname)
+	if err != nil {
+		t.Fatalf("LoadPolicyFile: %v", err)
+	}
+
+	alice := auth.Identity{Login: "alice"}
+	bob := auth.Identity{Login: "bob", Teams: []string{"lab"}}
+	admin := auth.Identity{Login: "root", Admin: true}
+	nwo := func(o, r string) common.NameWithOwner { return common.NameWithOwner{Owner: o, Repo: r} }
+
+	cases := []struct {
+		id    auth.Identity
+		repo  common.NameWithOwner
+		allow bool
+	}{
+		{alice, nwo("Octo-Org", "anything"), true},
+		{alice, nwo("acme", "lib-a"), false},
+		{bob, nwo("acme", "lib-a"), true},
+		{bob, nwo("acme", "app"), false},
+		{bob, nwo("github", "codeql"), true},
+		{bob, nwo("octo-org", "x"), false},
+		{auth.Identity{}, nwo("octo-org", "x"), false},
+		{admin, nwo("secret", "repo"), true},
+	}
+	for _, c := range cases {
+		if got := p.Allows(c.id, c.repo); got != c.allow {
+			t.Errorf("Allows(%s, %s/%s) = %v, want %v", c.id.Login, c.repo.Owner, c.repo.Repo, got, c.allow)
+		}
+	}
+
+	var none *auth.Policy
+	if !none.Allows(alice, nwo("any", "repo")) {
+		t.Error("nil policy denied access")
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/qldbimport"
+	"mrvacommander/pkg/qldbstore"
+	"mrvacommander/pkg/server"
+	"mrvacommander/utils"
+)
+
+// fakeRegistrar records registrations and how many ran at once.
+type fakeRegistrar struct {
+	delay time.Duration
+	known map[string]bool
+	fail  map[string]error // by owner/repo
+
+	mutex      sync.Mutex
+	registered []string
+	running    int
+	maxRunning int
+}
+
+func (f *fakeRegistrar) Register(nwo common.NameWithOwner, fname, language string) (qldbstore.DBInfo, error) {
+	f.mutex.Lock()
+	f.running++
+	f.maxRunning = max(f.maxRunning, f.running)
+	f.mutex.Unlock()
+
+	time.Sleep(f.delay)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.running--
+	if err := f.fail[nwo.Owner+"/"+nwo.Repo]; err != nil {
+		return qldbstore.DBInfo{}, err
+	}
+	f.registered = append(f.registered, nwo.Owner+"/"+nwo.Repo)
+	return qldbstore.DBInfo{Path: nwo.Owner + "/" + nwo.Repo + "/db.zip"}, nil
+}
+
+func (f *fakeRegistrar) Known(sum string) (bool, error) {
+	return f.known[sum], nil
+}
+
+// writeFile writes content to rel under dir and returns its path.
+func writeFile(t *testing.T, dir, rel, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func itemNames(items []qldbimport.Item) []string {
+	names := []string{}
+	for _, it := range items {
+		names = append(names, it.Owner+"/"+it.Repo+" "+it.Source())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestImportWalkDir(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "octo/lib/a.zip", "a")
+	b := writeFile(t, dir, "octo/lib/cpp/b.zip", "b")
+	c := writeFile(t, dir, "zed/app/c.zip", "c")
+	writeFile(t, dir, "top.zip", "not below a repository")
+	writeFile(t, dir, "octo/lib/.partial.zip", "hidden")
+	writeFile(t, dir, "octo/lib/README", "not an archive")
+
+	items, err := qldbimport.WalkDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := itemNames(items)
+	want := []string{"octo/lib " + a, "octo/lib " + b, "zed/app " + c}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestImportManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := writeFile(t, dir, "lists/manifest.jsonl",
+		`{"owner": "octo", "repo": "lib", "language": "cpp", "path": "dbs/lib.zip"}`+"\n"+
+			"\n"+
+			`{"owner": "zed", "repo": "app", "url": "https://example.com/app.zip"}`+"\n")
+
+	items, err := qldbimport.ReadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %+v", items)
+	}
+	if items[0].Path != filepath.Join(dir, "lists", "dbs", "lib.zip") || items[0].Language != "cpp" {
+		t.Errorf("relative path not resolved against the manifest: %+v", items[0])
+	}
+	if items[1].Source() != "https://example.com/app.zip" {
+		t.Errorf("url item %+v", items[1])
+	}
+
+	for name, line := range map[string]string{
+		"not json":       `{"owner": `,
+		"no repo":        `{"owner": "octo", "path": "lib.zip"}`,
+		"path and url":   `{"owner": "octo", "repo": "lib", "path": "lib.zip", "url": "https://example.com/lib.zip"}`,
+		"no path or url": `{"owner": "octo", "repo": "lib"}`,
+	} {
+		bad := writeFile(t, dir, "bad.jsonl", line+"\n")
+		if _, err := qldbimport.ReadManifest(bad); err == nil {
+			t.Errorf("%s: manifest accepted", name)
+		}
+	}
+}
+
+func TestImportConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 8; i++ {
+		writeFile(t, dir, fmt.Sprintf("octo/repo%d/db.zip", i), fmt.Sprintf("db %d", i))
+	}
+	// A copy of an archive in the tree and one the store already has
+	writeFile(t, dir, "copy/repo0/db.zip", "db 0")
+	stored := writeFile(t, dir, "octo/stored/db.zip", "stored")
+	sum, err := utils.FileChecksum(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := qldbimport.WalkDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := &fakeRegistrar{delay: 50 * time.Millisecond, known: map[string]bool{sum: true}}
+	imp, err := qldbimport.NewImporter(reg, filepath.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imp.Close()
+
+	report := imp.Run(items, 4)
+	if report.Imported != 8 || report.Duplicate != 2 || len(report.Failed) != 0 {
+		t.Errorf("report %+v", report)
+	}
+	if len(reg.registered) != 8 {
+		t.Errorf("registered %v", reg.registered)
+	}
+	if reg.maxRunning < 2 || reg.maxRunning > 4 {
+		t.Errorf("%d imports ran at once, want 2 to 4", reg.maxRunning)
+	}
+}
+
+func TestImportResume(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(t.TempDir(), "state")
+	for _, repo := range []string{"done", "invalid", "failed"} {
+		writeFile(t, dir, "octo/"+repo+"/db.zip", repo)
+	}
+	items, err := qldbimport.WalkDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(reg *fakeRegistrar) qldbimport.Report {
+		imp, err := qldbimport.NewImporter(reg, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer imp.Close()
+		return imp.Run(items, 2)
+	}
+
+	first := &fakeRegistrar{fail: map[string]error{
+		"octo/invalid": fmt.Errorf("%w: no codeql-database.yml", qldbstore.ErrInvalidDatabase),
+		"octo/failed":  errors.New("connection refused"),
+	}}
+	report := run(first)
+	if report.Imported != 1 || len(report.Invalid) != 1 || len(report.Failed) != 1 {
+		t.Fatalf("first run %+v", report)
+	}
+
+	// Only the invalid and failed archives are tried again
+	second := &fakeRegistrar{}
+	report = run(second)
+	if report.Resumed != 1 || report.Imported != 2 || len(report.Invalid) != 0 || len(report.Failed) != 0 {
+		t.Errorf("second run %+v", report)
+	}
+	sort.Strings(second.registered)
+	if fmt.Sprint(second.registered) != "[octo/failed octo/invalid]" {
+		t.Errorf("second run registered %v", second.registered)
+	}
+
+	third := &fakeRegistrar{}
+	if report := run(third); report.Resumed != 3 || len(third.registered) != 0 {
+		t.Errorf("third run %+v, registered %v", report, third.registered)
+	}
+}
+
+func TestImportReport(t *testing.T) {
+	dir := t.TempDir()
+	good := zipBytes(t, map[string]string{
+		"lib-db/codeql-database.yml":   "primaryLanguage: go\ncreationMetadata:\n  sha: 0123abcd\n",
+		"lib-db/db-go/default/strings": "x",
+	})
+	writeFile(t, dir, "octo/lib/db.zip", string(good))
+	writeFile(t, dir, "octo/copy/db.zip", string(good))
+	bad := writeFile(t, dir, "octo/bad/db.zip", "not a zip")
+
+	store, err := qldbstore.NewStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := qldbimport.WalkDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp, err := qldbimport.NewImporter(qldbimport.LocalStore{Store: store}, filepath.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imp.Close()
+	report := imp.Run(items, 2)
+
+	fname := filepath.Join(t.TempDir(), "report.json")
+	if err := qldbimport.WriteReport(fname, report); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Imported  int                 `json:"imported"`
+		Duplicate int                 `json:"duplicate"`
+		Invalid   []qldbimport.Record `json:"invalid"`
+		Failed    []qldbimport.Record `json:"failed"`
+	}
+	if err := json.Unmarshal(buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Imported != 1 || got.Duplicate != 1 || got.Failed == nil || len(got.Failed) != 0 {
+		t.Errorf("report %s", buf)
+	}
+	if len(got.Invalid) != 1 || got.Invalid[0].Source != bad || got.Invalid[0].Owner != "octo" ||
+		got.Invalid[0].Repo != "bad" || got.Invalid[0].Error == "" {
+		t.Errorf("invalid archives %+v", got.Invalid)
+	}
+}
+
+func TestListDatabasesByChecksum(t *testing.T) {
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "lib"}
+	c, _ := newTestCommander(t, mcc.Commander{}, loginTokens{}, nwo)
+	sum, err := utils.FileChecksum(filepath.Join("codeql", "dbs", "octo", "lib", "octo_lib_db.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(checksum string) int {
+		req := httptest.NewRequest(http.MethodGet, "/databases?checksum="+checksum, nil)
+		req.Header.Set("Authorization", "Bearer alice")
+		rec := httptest.NewRecorder()
+		c.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("listing: %d %s", rec.Code, rec.Body.String())
+		}
+		var list server.DatabaseList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		return list.TotalCount
+	}
+	if n := count(sum); n != 1 {
+		t.Errorf("%d databases with the checksum, want 1", n)
+	}
+	if n := count(fmt.Sprintf("%064d", 0)); n != 0 {
+		t.Errorf("%d databases with an unknown checksum, want 0", n)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/qldbstore"
+	"mrvacommander/utils"
+)
+
+func TestFindAvailableDBsByLanguage(t *testing.T) {
+	root := t.TempDir()
+	goRepo := common.NameWithOwner{Owner: "octo", Repo: "gorepo"}
+	pyRepo := common.NameWithOwner{Owner: "octo", Repo: "pyrepo"}
+	missing := common.NameWithOwner{Owner: "octo", Repo: "missing"}
+	writeZip(t, filepath.Join(root, "octo/gorepo/octo_gorepo_db.zip"), map[string]string{"codeql_db/codeql-database.yml": `primaryLanguage: "go"
+creationMetadata:
+  sha: "0123abcd"
+  cliVersion: "2.17.0"
+  creationTime: "2024-05-01T10:00:00Z"
+`})
+	writeZip(t, filepath.Join(root, "octo/pyrepo/octo_pyrepo_db.zip"), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: python\n"})
+
+	s, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs := s.Databases(goRepo)
+	if len(dbs) != 1 {
+		t.Fatalf("databases = %+v", dbs)
+	}
+	if info := dbs[0]; info.Language != "go" || info.SHA != "0123abcd" || info.CLIVersion != "2.17.0" {
+		t.Fatalf("unexpected index entry %+v", dbs[0])
+	}
+
+	notFound, noDB, analysisRepos := s.FindAvailableDBs([]common.NameWithOwner{goRepo, pyRepo, missing}, "go", nil)
+	if len(notFound) != 1 || notFound[0] != missing {
+		t.Errorf("not found = %v", notFound)
+	}
+	if len(noDB) != 1 || noDB[0] != pyRepo {
+		t.Errorf("no database = %v", noDB)
+	}
+	if _, ok := (*analysisRepos)[goRepo]; !ok || len(*analysisRepos) != 1 {
+		t.Errorf("analysis repos = %v", *analysisRepos)
+	}
+
+	// The index is persisted and picked up by a new store
+	if _, err := os.Stat(filepath.Join(root, "index.json")); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbs := s2.Databases(pyRepo); len(dbs) != 1 || dbs[0].Language != "python" {
+		t.Errorf("reloaded entries %+v", dbs)
+	}
+}
+
+func TestFindAvailableDBsNewestAndPinned(t *testing.T) {
+	root := t.TempDir()
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "mixed"}
+	for _, db := range []struct{ lang, sha, created string }{
+		{"cpp", "aaaa1111", "2024-01-01T00:00:00Z"},
+		{"cpp", "bbbb2222", "2024-03-01T00:00:00Z"},
+		{"python", "cccc3333", "2024-06-01T00:00:00Z"},
+	} {
+		writeZip(t, filepath.Join(root, qldbstore.StorePath(nwo, db.lang, db.sha)), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: " + db.lang + "\ncreationMetadata:\n  sha: " + db.sha + "\n  creationTime: " + db.created + "\n"})
+	}
+	s, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := []common.NameWithOwner{nwo}
+
+	cases := []struct {
+		lang, pin, want string
+	}{
+		{"cpp", "", "bbbb2222"},
+		{"python", "", "cccc3333"},
+		{"cpp", "AAAA111", "aaaa1111"},
+	}
+	for _, c := range cases {
+		pins := map[common.NameWithOwner]string{}
+		if c.pin != "" {
+			pins[nwo] = c.pin
+		}
+		_, _, analysisRepos := s.FindAvailableDBs(repos, c.lang, pins)
+		loc, ok := (*analysisRepos)[nwo]
+		if !ok || loc.File != c.want+".zip" {
+			t.Errorf("%s@%s: got %+v", c.lang, c.pin, loc)
+		}
+	}
+
+	_, noDB, _ := s.FindAvailableDBs(repos, "cpp", map[common.NameWithOwner]string{nwo: "cccc333"})
+	if len(noDB) != 1 {
+		t.Errorf("pin of a python database matched a cpp query: %v", noDB)
+	}
+	_, noDB, _ = s.FindAvailableDBs(repos, "java", nil)
+	if len(noDB) != 1 {
+		t.Errorf("java matched: %v", noDB)
+	}
+}
+
+func TestListDatabases(t *testing.T) {
+	root := t.TempDir()
+	writeZip(t, filepath.Join(root, "zed/app/go/1111111.zip"), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: go\ncreationMetadata:\n  sha: 1111111\n  creationTime: 2024-01-01T00:00:00Z\n"})
+	writeZip(t, filepath.Join(root, "abc/lib/go/2222222.zip"), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: go\ncreationMetadata:\n  sha: 2222222\n  creationTime: 2024-01-01T00:00:00Z\n"})
+	writeZip(t, filepath.Join(root, "abc/lib/go/3333333.zip"), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: go\ncreationMetadata:\n  sha: 3333333\n  creationTime: 2024-02-01T00:00:00Z\n"})
+
+	s, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, info := range s.List() {
+		got = append(got, info.Owner+"/"+info.Repo+"@"+info.SHA)
+	}
+	want := []string{"abc/lib@3333333", "abc/lib@2222222", "zed/app@1111111"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestIndexChecksums(t *testing.T) {
+	root := t.TempDir()
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "lib"}
+	rel := "octo/lib/cpp/abc.zip"
+	writeZip(t, filepath.Join(root, rel), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: cpp\ncreationMetadata:\n  sha: abc\n"})
+	want, err := utils.FileChecksum(filepath.Join(root, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The checksum is computed when the archive is indexed, not per request
+	_, _, repos := s.FindAvailableDBs([]common.NameWithOwner{nwo}, "cpp", nil)
+	if loc := (*repos)[nwo]; loc.Checksum != want {
+		t.Errorf("location checksum = %q, want %q", loc.Checksum, want)
+	}
+	if info, ok := s.FindChecksum(want); !ok || info.Path != rel {
+		t.Errorf("FindChecksum = %+v, %v", info, ok)
+	}
+}
+
+func TestIndexChecksumOfChangedArchive(t *testing.T) {
+	root := t.TempDir()
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "lib"}
+	rel := "octo/lib/cpp/abc.zip"
+	writeZip(t, filepath.Join(root, rel), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: cpp\ncreationMetadata:\n  sha: abc\n"})
+	s, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := s.Databases(nwo)[0].Checksum
+
+	// The archive is replaced in place
+	writeZip(t, filepath.Join(root, rel), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: cpp\ncreationMetadata:\n  sha: abc\n  cliVersion: 2.17.0\n"})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, rel), later, later); err != nil {
+		t.Fatal(err)
+	}
+	want, err := utils.FileChecksum(filepath.Join(root, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, repos := s.FindAvailableDBs([]common.NameWithOwner{nwo}, "cpp", nil)
+	if got := (*repos)[nwo].Checksum; got != want || got == old {
+		t.Errorf("checksum after change = %q, want %q", got, want)
+	}
+}
+
+func TestIndexSharedBetweenStores(t *testing.T) {
+	root := t.TempDir()
+	archive := func(repo string) []byte {
+		return zipBytes(t, map[string]string{
+			repo + "-db/codeql-database.yml": "primaryLanguage: go\ncreationMetadata:\n  sha: 0123abcd\n",
+			repo + "-db/db-go/default/x":     "x",
+		})
+	}
+
+	// Like the server and a concurrent qldb import
+	server, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	importer, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importer.Add(common.NameWithOwner{Owner: "octo", Repo: "a"}, bytes.NewReader(archive("a")), "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Add(common.NameWithOwner{Owner: "octo", Repo: "b"}, bytes.NewReader(archive("b")), "", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(filepath.Join(root, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f struct {
+		Databases []qldbstore.DBInfo `json:"databases"`
+	}
+	if err := json.Unmarshal(buf, &f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Databases) != 2 {
+		t.Errorf("index has %d databases, want both", len(f.Databases))
+	}
+	for _, info := range f.Databases {
+		if info.Checksum == "" {
+			t.Errorf("%s has no checksum", info.Path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "index.json.lock")); err == nil {
+		t.Error("index lock left behind")
+	}
+}
+
+func TestFindAvailableDBsRejectsPathNames(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "store", "dbs")
+	writeZip(t, filepath.Join(root, "octo/lib/octo_lib_db.zip"), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: cpp\n"})
+	// An archive outside the store that ../.. would reach
+	writeZip(t, filepath.Join(dir, "secret/outside.zip"), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: cpp\n"})
+
+	s, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := []common.NameWithOwner{{Owner: "..", Repo: ".."}, {Owner: ".", Repo: "secret"}, {Owner: "octo", Repo: "a/b"}}
+	notFound, _, repos := s.FindAvailableDBs(bad, "cpp", nil)
+	if len(notFound) != len(bad) || len(*repos) != 0 {
+		t.Errorf("not found %v, found %v", notFound, *repos)
+	}
+	for _, info := range s.List() {
+		if info.Owner != "octo" || info.Repo != "lib" {
+			t.Errorf("indexed %+v", info)
+		}
+	}
+}
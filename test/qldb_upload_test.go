@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/qldbstore"
+)
+
+func TestAddDatabase(t *testing.T) {
+	s, err := qldbstore.NewStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "lib"}
+	good := zipBytes(t, map[string]string{
+		"lib-db/codeql-database.yml":   "primaryLanguage: go\ncreationMetadata:\n  sha: 0123abcd\n",
+		"lib-db/db-go/default/strings": "x",
+	})
+
+	info, err := s.Add(nwo, bytes.NewReader(good), "go", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Language != "go" || info.SHA != "0123abcd" || info.Path != qldbstore.StorePath(nwo, "go", "0123abcd") {
+		t.Errorf("unexpected entry %+v", info)
+	}
+	if len(info.Checksum) != 64 {
+		t.Errorf("checksum %q", info.Checksum)
+	}
+	if found, ok := s.FindChecksum(info.Checksum); !ok || found.Path != info.Path {
+		t.Errorf("checksum lookup found %+v", found)
+	}
+	_, _, analysisRepos := s.FindAvailableDBs([]common.NameWithOwner{nwo}, "go", nil)
+	if _, ok := (*analysisRepos)[nwo]; !ok {
+		t.Error("uploaded database not found")
+	}
+
+	bad := map[string]struct {
+		nwo      common.NameWithOwner
+		archive  []byte
+		language string
+		max      int64
+	}{
+		"not a zip":     {nwo, []byte("not a zip"), "", 0},
+		"no metadata":   {nwo, zipBytes(t, map[string]string{"db-go/x": "x"}), "", 0},
+		"no dataset":    {nwo, zipBytes(t, map[string]string{"codeql-database.yml": "primaryLanguage: go\n"}), "", 0},
+		"language":      {nwo, good, "python", 0},
+		"too large":     {nwo, good, "", 10},
+		"path in owner": {common.NameWithOwner{Owner: "..", Repo: "lib"}, good, "", 0},
+	}
+	for name, c := range bad {
+		if _, err := s.Add(c.nwo, bytes.NewReader(c.archive), c.language, c.max); !errors.Is(err, qldbstore.ErrInvalidDatabase) {
+			t.Errorf("%s: got %v, want an invalid database error", name, err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/server"
+	"mrvacommander/pkg/storage"
+)
+
+// loginTokens authenticates every token as the actor of the same login.
+type loginTokens struct{}
+
+func (loginTokens) Authenticate(token string) (auth.Identity, error) {
+	return auth.Identity{Login: token}, nil
+}
+
+// queryPack returns a base64 encoded query pack.
+func queryPack(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	body := []byte("select 1\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "query.ql", Mode: 0644, Size: int64(len(body))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(body)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// submit posts a variant analysis of repos to the octo/ctl controller as
+// login.
+func submit(t *testing.T, c *server.CommanderSingle, login string, repos ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(common.SubmitMsg{Language: "cpp", QueryPack: queryPack(t), Repositories: repos})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/repos/octo/ctl/code-scanning/codeql/variant-analyses",
+		bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+login)
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func overLimit(t *testing.T, rec *httptest.ResponseRecorder) int {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("submission: %d %s", rec.Code, rec.Body.String())
+	}
+	var sr common.SubmitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &sr); err != nil {
+		t.Fatal(err)
+	}
+	return sr.SkippedRepositories.OverLimitRepos.RepositoryCount
+}
+
+func quotaRepos(n int) ([]common.NameWithOwner, []string) {
+	nwos := []common.NameWithOwner{}
+	names := []string{}
+	for i := 0; i < n; i++ {
+		nwo := common.NameWithOwner{Owner: "octo", Repo: fmt.Sprintf("lib%d", i)}
+		nwos = append(nwos, nwo)
+		names = append(names, nwo.Owner+"/"+nwo.Repo)
+	}
+	return nwos, names
+}
+
+func registerQuotaController() {
+	storage.SetController(common.Controller{Owner: "octo", Name: "ctl"})
+}
+
+func TestConcurrentSessionQuota(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	c, _ := newTestCommander(t, mcc.Commander{Quotas: mcc.Quotas{MaxConcurrentSessions: 1}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+
+	// Of simultaneous submissions only one may start
+	codes := make(chan int, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- submit(t, c, "alice", names...).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	accepted := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			accepted++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("submission: %d", code)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("%d sessions accepted, want 1", accepted)
+	}
+
+	// Other actors have their own limit
+	if rec := submit(t, c, "bob", names...); rec.Code != http.StatusOK {
+		t.Errorf("other actor: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGlobalConcurrentSessionQuota(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	c, _ := newTestCommander(t, mcc.Commander{Quotas: mcc.Quotas{GlobalMaxConcurrentSessions: 2}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+
+	for _, login := range []string{"alice", "bob"} {
+		if rec := submit(t, c, login, names...); rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", login, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := submit(t, c, "carol", names...); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("third session: %d, want 429", rec.Code)
+	}
+}
+
+func TestDailyBudget(t *testing.T) {
+	nwos, names := quotaRepos(4)
+	c, _ := newTestCommander(t, mcc.Commander{Quotas: mcc.Quotas{DailyAnalysisBudget: 3}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+
+	// Repositories beyond the budget are skipped
+	if n := overLimit(t, submit(t, c, "alice", names[:2]...)); n != 0 {
+		t.Errorf("first session: %d over limit", n)
+	}
+	if n := overLimit(t, submit(t, c, "alice", names[2:]...)); n != 1 {
+		t.Errorf("second session: %d over limit, want 1", n)
+	}
+
+	// Then the actor is turned away until tomorrow
+	rec := submit(t, c, "alice", names[:1]...)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("exhausted budget: %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if rec := submit(t, c, "bob", names[:1]...); rec.Code != http.StatusOK {
+		t.Errorf("other actor: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGlobalDailyBudget(t *testing.T) {
+	nwos, names := quotaRepos(3)
+	c, _ := newTestCommander(t, mcc.Commander{Quotas: mcc.Quotas{GlobalDailyAnalysisBudget: 3}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+
+	if n := overLimit(t, submit(t, c, "alice", names[:2]...)); n != 0 {
+		t.Errorf("alice: %d over limit", n)
+	}
+	if n := overLimit(t, submit(t, c, "bob", names...)); n != 2 {
+		t.Errorf("bob: %d over limit, want 2", n)
+	}
+	if rec := submit(t, c, "carol", names[:1]...); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("exhausted server budget: %d, want 429", rec.Code)
+	}
+}
+
+func TestQuotaCheckedWhileQueueIsFull(t *testing.T) {
+	nwos, names := quotaRepos(12)
+	c, q := newTestCommander(t, mcc.Commander{Quotas: mcc.Quotas{MaxConcurrentSessions: 1}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+
+	// More analyses than the queue holds: the submission waits for room
+	first := make(chan int, 1)
+	go func() { first <- submit(t, c, "alice", names...).Code }()
+	waitFor(t, "full queue", func() bool { return len(q.Jobs()) == cap(q.Jobs()) })
+
+	second := make(chan int, 1)
+	go func() { second <- submit(t, c, "alice", names[:1]...).Code }()
+	select {
+	case code := <-second:
+		if code != http.StatusTooManyRequests {
+			t.Errorf("second session: %d, want 429", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("quota check blocked by a submission waiting for the queue")
+	}
+
+	for i := 0; i < len(nwos); i++ {
+		nextJob(t, q)
+	}
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first session: %d", code)
+	}
+}
+
+func TestStalledSessionsDoNotHoldQuota(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	c, _ := newTestCommander(t, mcc.Commander{
+		Quotas:     mcc.Quotas{MaxConcurrentSessions: 1},
+		JobTimeout: 200 * time.Millisecond,
+	}, loginTokens{}, nwos...)
+	registerQuotaController()
+
+	// No agent picks up the analysis
+	if rec := submit(t, c, "alice", names...); rec.Code != http.StatusOK {
+		t.Fatalf("first session: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := submit(t, c, "alice", names...); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second session while the first is pending: %d, want 429", rec.Code)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if rec := submit(t, c, "alice", names...); rec.Code != http.StatusOK {
+		t.Errorf("second session after the job timeout: %d %s", rec.Code, rec.Body.String())
+	}
+}
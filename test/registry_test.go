@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/server"
+	"mrvacommander/pkg/storage"
+)
+
+// adminTokens authenticates a token as that login; root is an admin.
+type adminTokens struct{}
+
+func (adminTokens) Authenticate(token string) (auth.Identity, error) {
+	return auth.Identity{Login: token, Admin: token == "root"}, nil
+}
+
+// request sends a request with an optional body to c as login.
+func request(t *testing.T, c *server.CommanderSingle, login, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+login)
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+// submitAt posts a variant analysis of repos to path as login.
+func submitAt(t *testing.T, c *server.CommanderSingle, login, path string, repos ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(common.SubmitMsg{Language: "cpp", QueryPack: queryPack(t), Repositories: repos})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+login)
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+// forgetControllers removes the controllers registered by the test.
+func forgetControllers(t *testing.T) {
+	before := map[int]bool{}
+	for _, cr := range storage.ListControllers() {
+		before[cr.ID] = true
+	}
+	t.Cleanup(func() {
+		for _, cr := range storage.ListControllers() {
+			if !before[cr.ID] {
+				storage.RemoveController(cr.ID)
+			}
+		}
+	})
+}
+
+func TestConfiguredControllers(t *testing.T) {
+	forgetControllers(t)
+	nwos, names := quotaRepos(1)
+	c, _ := newTestCommander(t, mcc.Commander{ControllerRepos: []mcc.ControllerRepo{
+		{Owner: "acme", Name: "mrva-ctl"},
+		{ID: 4242, Owner: "acme", Name: "public-ctl", Visibility: "public"},
+	}}, adminTokens{}, nwos...)
+
+	rec := request(t, c, "root", http.MethodGet, "/admin/controller-repos", "")
+	var list []common.Controller
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("%d %s: %v", rec.Code, rec.Body.String(), err)
+	}
+	found := map[string]common.Controller{}
+	for _, cr := range list {
+		found[cr.Owner+"/"+cr.Name] = cr
+	}
+	derived := found["acme/mrva-ctl"]
+	if derived.ID <= 0 || derived.Visibility != "private" {
+		t.Errorf("controller with defaults = %+v", derived)
+	}
+	if cr := found["acme/public-ctl"]; cr.ID != 4242 || cr.Visibility != "public" {
+		t.Errorf("controller with id = %+v", cr)
+	}
+
+	// Both the name and the id of a controller accept submissions, and the
+	// reply describes the controller
+	for _, path := range []string{
+		"/repos/acme/mrva-ctl/code-scanning/codeql/variant-analyses",
+		"/repos/ACME/Mrva-Ctl/code-scanning/codeql/variant-analyses",
+		fmt.Sprintf("/%d/code-scanning/codeql/variant-analyses", derived.ID),
+	} {
+		rec := submitAt(t, c, "alice", path, names...)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: %d %s", path, rec.Code, rec.Body.String())
+			continue
+		}
+		var sr common.SubmitResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &sr); err != nil {
+			t.Fatal(err)
+		}
+		if cr := sr.ControllerRepo; cr.ID != derived.ID || cr.FullName != "acme/mrva-ctl" || !cr.Private {
+			t.Errorf("%s: controller_repo = %+v", path, cr)
+		}
+	}
+
+	if rec := submitAt(t, c, "alice", "/repos/acme/unknown/code-scanning/codeql/variant-analyses", names...); rec.Code != http.StatusNotFound {
+		t.Errorf("submission to an unregistered controller: %d, want 404", rec.Code)
+	}
+	if rec := submitAt(t, c, "alice", "/1/code-scanning/codeql/variant-analyses", names...); rec.Code != http.StatusNotFound {
+		t.Errorf("submission to an unregistered id: %d, want 404", rec.Code)
+	}
+}
+
+func TestControllerAdminAPI(t *testing.T) {
+	forgetControllers(t)
+	nwos, names := quotaRepos(1)
+	c, _ := newTestCommander(t, mcc.Commander{}, adminTokens{}, nwos...)
+	const path = "/repos/acme/added-ctl/code-scanning/codeql/variant-analyses"
+
+	// Only admins may use the registry
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodGet, "/admin/controller-repos", ""},
+		{http.MethodPost, "/admin/controller-repos", `{"owner":"acme","name":"added-ctl"}`},
+		{http.MethodDelete, "/admin/controller-repos/1", ""},
+	} {
+		if rec := request(t, c, "alice", req.method, req.path, req.body); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s as non-admin: %d, want 403", req.method, req.path, rec.Code)
+		}
+	}
+	if rec := submitAt(t, c, "alice", path, names...); rec.Code != http.StatusNotFound {
+		t.Errorf("submission before registration: %d, want 404", rec.Code)
+	}
+
+	rec := request(t, c, "root", http.MethodPost, "/admin/controller-repos", `{"owner":"acme","name":"added-ctl"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("registration: %d %s", rec.Code, rec.Body.String())
+	}
+	var added common.Controller
+	if err := json.Unmarshal(rec.Body.Bytes(), &added); err != nil {
+		t.Fatal(err)
+	}
+	if added.ID <= 0 || added.Visibility != "private" {
+		t.Errorf("registered controller = %+v", added)
+	}
+	if rec := submitAt(t, c, "alice", path, names...); rec.Code != http.StatusOK {
+		t.Errorf("submission after registration: %d %s", rec.Code, rec.Body.String())
+	}
+
+	for _, tc := range []struct {
+		body string
+		want int
+	}{
+		{`{"owner":"acme"`, http.StatusBadRequest},
+		{`{"owner":"acme","name":""}`, http.StatusUnprocessableEntity},
+		{`{"owner":"acme/x","name":"ctl"}`, http.StatusUnprocessableEntity},
+		{`{"owner":"acme","name":"ctl","visibility":"secret"}`, http.StatusUnprocessableEntity},
+		{`{"owner":"acme","name":"ctl","id":-1}`, http.StatusUnprocessableEntity},
+		// The name is taken, under another id
+		{fmt.Sprintf(`{"owner":"ACME","name":"added-ctl","id":%d}`, added.ID+1), http.StatusUnprocessableEntity},
+		// The id is taken by another controller
+		{fmt.Sprintf(`{"owner":"acme","name":"other-ctl","id":%d}`, added.ID), http.StatusUnprocessableEntity},
+	} {
+		if rec := request(t, c, "root", http.MethodPost, "/admin/controller-repos", tc.body); rec.Code != tc.want {
+			t.Errorf("registration of %s: %d, want %d", tc.body, rec.Code, tc.want)
+		}
+	}
+
+	// Registering a controller again is harmless
+	if rec := request(t, c, "root", http.MethodPost, "/admin/controller-repos",
+		`{"owner":"acme","name":"added-ctl"}`); rec.Code != http.StatusCreated {
+		t.Errorf("repeated registration: %d %s", rec.Code, rec.Body.String())
+	}
+
+	del := fmt.Sprintf("/admin/controller-repos/%d", added.ID)
+	if rec := request(t, c, "root", http.MethodDelete, del, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("removal: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := request(t, c, "root", http.MethodDelete, del, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("repeated removal: %d, want 404", rec.Code)
+	}
+	if rec := submitAt(t, c, "alice", path, names...); rec.Code != http.StatusNotFound {
+		t.Errorf("submission after removal: %d, want 404", rec.Code)
+	}
+}
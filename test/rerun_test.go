@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/queue"
+	"mrvacommander/pkg/server"
+	"mrvacommander/pkg/storage"
+)
+
+// rerun posts a re-run of session vaid as login.
+func rerun(t *testing.T, c *server.CommanderSingle, login string, vaid int, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost,
+		fmt.Sprintf("/repos/octo/ctl/code-scanning/codeql/variant-analyses/%d/rerun%s", vaid, query), nil)
+	req.Header.Set("Authorization", "Bearer "+login)
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+// nextJob returns the next job queued for the agents.
+func nextJob(t *testing.T, q *queue.QueueSingle) common.AnalyzeJob {
+	t.Helper()
+	select {
+	case job := <-q.Jobs():
+		return job
+	case <-time.After(5 * time.Second):
+		t.Fatal("no job queued")
+		return common.AnalyzeJob{}
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	nwos, names := quotaRepos(2)
+	c, q := newTestCommander(t, mcc.Commander{Quotas: mcc.Quotas{DailyAnalysisBudget: 3}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+
+	rec := submit(t, c, "alice", names...)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("submission: %d %s", rec.Code, rec.Body.String())
+	}
+	var sr common.SubmitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &sr); err != nil {
+		t.Fatal(err)
+	}
+	for range nwos {
+		nextJob(t, q)
+	}
+
+	// Nothing has failed yet
+	if rec := rerun(t, c, "alice", sr.ID, "?failed_only=true"); rec.Code != http.StatusConflict {
+		t.Errorf("retry without failures: %d, want 409", rec.Code)
+	}
+
+	q.Results() <- common.AnalyzeResult{Status: common.StatusSuccess, QueryPackId: sr.ID, NWO: nwos[0],
+		ResultArchive: zipBytes(t, map[string]string{"results.sarif": sampleSarif})}
+	q.Results() <- common.AnalyzeResult{Status: common.StatusFailed, QueryPackId: sr.ID, NWO: nwos[1]}
+	waitStatus(t, sr.ID, nwos[1], common.StatusFailed)
+
+	// Only the failed repository is queued again, within the session
+	if rec := rerun(t, c, "alice", sr.ID, "?failed_only=true"); rec.Code != http.StatusOK {
+		t.Fatalf("retry: %d %s", rec.Code, rec.Body.String())
+	}
+	if job := nextJob(t, q); job.NWO != nwos[1] || job.QueryPackId != sr.ID {
+		t.Errorf("retried job = %+v", job)
+	}
+	if st := storage.GetStatus(sr.ID, nwos[0]); st != common.StatusSuccess {
+		t.Errorf("succeeded repository status = %v", st)
+	}
+
+	// The retry used up the daily budget of three
+	if rec := rerun(t, c, "alice", sr.ID, ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("re-run over budget: %d, want 429", rec.Code)
+	}
+}
+
+func TestRerunSession(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	c, q := newTestCommander(t, mcc.Commander{}, loginTokens{}, nwos...)
+	registerQuotaController()
+
+	rec := submit(t, c, "alice", names...)
+	var first common.SubmitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+		t.Fatal(err)
+	}
+	nextJob(t, q)
+
+	// Other actors cannot re-run the session
+	if rec := rerun(t, c, "bob", first.ID, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("re-run by another actor: %d, want 403", rec.Code)
+	}
+
+	rec = rerun(t, c, "alice", first.ID, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("re-run: %d %s", rec.Code, rec.Body.String())
+	}
+	var second common.SubmitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &second); err != nil {
+		t.Fatal(err)
+	}
+	if second.ID == first.ID {
+		t.Error("re-run did not start a new session")
+	}
+	if job := nextJob(t, q); job.QueryPackId != second.ID || job.NWO != nwos[0] {
+		t.Errorf("re-run job = %+v", job)
+	}
+}
+
+func TestRetryOverLimit(t *testing.T) {
+	nwos, names := quotaRepos(2)
+	c, q := newTestCommander(t, mcc.Commander{Quotas: mcc.Quotas{DailyAnalysisBudget: 3}},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+
+	rec := submit(t, c, "alice", names...)
+	var sr common.SubmitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &sr); err != nil {
+		t.Fatal(err)
+	}
+	for _, nwo := range nwos {
+		nextJob(t, q)
+		q.Results() <- common.AnalyzeResult{Status: common.StatusError, QueryPackId: sr.ID, NWO: nwo}
+		waitStatus(t, sr.ID, nwo, common.StatusError)
+	}
+
+	// One analysis is left of the daily budget
+	rec = rerun(t, c, "alice", sr.ID, "?failed_only=true")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("retry: %d %s", rec.Code, rec.Body.String())
+	}
+	var status common.StatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	job := nextJob(t, q)
+	over := status.SkippedRepositories.OverLimitRepos
+	skipped := nwos[0]
+	if job.NWO == nwos[0] {
+		skipped = nwos[1]
+	}
+	if over.RepositoryCount != 1 || len(over.Repositories) != 1 ||
+		over.Repositories[0] != skipped.Owner+"/"+skipped.Repo {
+		t.Errorf("over limit repositories %+v, want %v", over, skipped)
+	}
+}
+
+func TestRetryTimedOut(t *testing.T) {
+	nwos, names := quotaRepos(1)
+	c, q := newTestCommander(t, mcc.Commander{JobTimeout: 100 * time.Millisecond},
+		loginTokens{}, nwos...)
+	registerQuotaController()
+
+	rec := submit(t, c, "alice", names...)
+	var sr common.SubmitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &sr); err != nil {
+		t.Fatal(err)
+	}
+	// The agent takes the job and is never heard of again
+	nextJob(t, q)
+	waitStatus(t, sr.ID, nwos[0], common.StatusError)
+
+	if rec := rerun(t, c, "alice", sr.ID, "?failed_only=true"); rec.Code != http.StatusOK {
+		t.Fatalf("retry: %d %s", rec.Code, rec.Body.String())
+	}
+	if job := nextJob(t, q); job.NWO != nwos[0] || job.QueryPackId != sr.ID {
+		t.Errorf("retried job = %+v", job)
+	}
+}
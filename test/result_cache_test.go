@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/queue"
+	"mrvacommander/pkg/storage"
+)
+
+// startSession submits nwo on the database at loc and reports whether a job
+// was queued rather than answered from the cache.
+func startSession(t *testing.T, q *queue.QueueSingle, vaid int, nwo common.NameWithOwner,
+	loc storage.DBLocation, queryPackHash string) bool {
+	t.Helper()
+	storage.SetSession(common.Session{ID: vaid, Language: "cpp", CreatedAt: time.Now()})
+	q.StartAnalyses(&map[common.NameWithOwner]storage.DBLocation{nwo: loc}, vaid, "cpp", queryPackHash)
+	select {
+	case job := <-q.Jobs():
+		if job.DatabaseChecksum != loc.Checksum {
+			t.Errorf("job database checksum = %q, want %q", job.DatabaseChecksum, loc.Checksum)
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	_, q := newTestCommander(t, mcc.Commander{}, nil)
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "cached"}
+	loc := storage.DBLocation{Prefix: "/dbs", File: "db.zip", Checksum: "cache-test-db-1"}
+	key := common.CacheKey{QueryPackHash: "cache-test-qp-1", DatabaseChecksum: loc.Checksum,
+		CLIVersion: "cache-test-2.0.0"}
+
+	// Miss: nothing has run yet
+	if !startSession(t, q, 990201, nwo, loc, key.QueryPackHash) {
+		t.Fatal("first session answered from the cache")
+	}
+	// The agent reports the result with its own CLI version
+	q.Results() <- common.AnalyzeResult{Status: common.StatusSuccess, QueryPackId: 990201, NWO: nwo,
+		ResultCount: 2, ResultArchive: zipBytes(t, map[string]string{"results.sarif": sampleSarif}), CacheKey: key}
+	waitFor(t, "cached result", func() bool {
+		_, ok := storage.GetCachedResult(key)
+		return ok
+	})
+
+	// Hit: same query pack on the same database
+	if startSession(t, q, 990202, nwo, loc, key.QueryPackHash) {
+		t.Fatal("second session queued a job")
+	}
+	if st := storage.GetStatus(990202, nwo); st != common.StatusSuccess {
+		t.Errorf("cached job status = %v", st)
+	}
+	res := storage.GetResult(common.JobSpec{JobID: 990202, NameWithOwner: nwo})
+	if !res.CacheHit || res.ResultCount != 2 {
+		t.Errorf("cached result = %+v", res)
+	}
+	zpath, err := storage.ResultArchivePath(nwo, 990202)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(zpath); err != nil {
+		t.Errorf("cached archive not linked: %v", err)
+	}
+
+	// Invalidation: another database or query pack misses
+	other := loc
+	other.Checksum = "cache-test-db-2"
+	if !startSession(t, q, 990203, nwo, other, key.QueryPackHash) {
+		t.Error("changed database answered from the cache")
+	}
+	if !startSession(t, q, 990204, nwo, loc, "cache-test-qp-2") {
+		t.Error("changed query pack answered from the cache")
+	}
+	// Databases without an indexed checksum are never cached
+	unknown := loc
+	unknown.Checksum = ""
+	if !startSession(t, q, 990205, nwo, unknown, key.QueryPackHash) {
+		t.Error("database without checksum answered from the cache")
+	}
+
+	// Results of a CLI version no agent reported are not reused
+	retired := common.CacheKey{QueryPackHash: "cache-test-qp-3", DatabaseChecksum: loc.Checksum,
+		CLIVersion: "cache-test-retired"}
+	storage.SetCachedResult(retired, storage.CachedResult{Result: res, ArchivePath: zpath})
+	if !startSession(t, q, 990206, nwo, loc, retired.QueryPackHash) {
+		t.Error("result of a retired CLI version reused")
+	}
+
+	// Removing the archive drops the entry
+	first, err := storage.ResultArchivePath(nwo, 990201)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(first); err != nil {
+		t.Fatal(err)
+	}
+	if !startSession(t, q, 990207, nwo, loc, key.QueryPackHash) {
+		t.Error("result without archive reused")
+	}
+	if _, ok := storage.GetCachedResult(key); ok {
+		t.Error("entry without archive still cached")
+	}
+}
+
+func TestResultCacheNeedsArchive(t *testing.T) {
+	_, q := newTestCommander(t, mcc.Commander{}, nil)
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "noarchive"}
+	key := common.CacheKey{QueryPackHash: "cache-test-qp-4", DatabaseChecksum: "cache-test-db-4",
+		CLIVersion: "cache-test-2.0.0"}
+
+	storage.SetSession(common.Session{ID: 990211, CreatedAt: time.Now()})
+	storage.AddJob(990211, common.AnalyzeJob{QueryPackId: 990211, NWO: nwo})
+	q.Results() <- common.AnalyzeResult{Status: common.StatusSuccess, QueryPackId: 990211, NWO: nwo, CacheKey: key}
+	waitStatus(t, 990211, nwo, common.StatusError)
+
+	if _, ok := storage.GetCachedResult(key); ok {
+		t.Error("result without stored archive cached")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/auth"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/qldbstore"
+	"mrvacommander/pkg/queue"
+	"mrvacommander/pkg/server"
+	"mrvacommander/pkg/storage"
+)
+
+// newTestCommander runs a commander with an in-process queue in a fresh
+// working directory, where it keeps query packs, results and a database
+// store with a cpp database of each of repos.
+func newTestCommander(t *testing.T, cfg mcc.Commander, authn auth.Authenticator,
+	repos ...common.NameWithOwner) (*server.CommanderSingle, *queue.QueueSingle) {
+	t.Helper()
+	return startCommander(t, inTempDir(t), cfg, authn, repos...)
+}
+
+// inTempDir runs the rest of the test in a fresh working directory, which
+// it returns.  Sessions created by the test are deleted afterwards.
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	before := map[int]bool{}
+	for _, sn := range storage.ListSessions(func(common.Session) bool { return true }) {
+		before[sn.ID] = true
+	}
+	t.Cleanup(func() {
+		for _, sn := range storage.ListSessions(func(common.Session) bool { return true }) {
+			if !before[sn.ID] {
+				storage.DeleteSession(sn.ID)
+			}
+		}
+	})
+	return dir
+}
+
+// startCommander runs a commander in the working directory dir, as set up
+// by newTestCommander.
+func startCommander(t *testing.T, dir string, cfg mcc.Commander, authn auth.Authenticator,
+	repos ...common.NameWithOwner) (*server.CommanderSingle, *queue.QueueSingle) {
+	t.Helper()
+	root := filepath.Join(dir, "codeql", "dbs")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, nwo := range repos {
+		writeZip(t, filepath.Join(root, fmt.Sprintf("%s/%s/%s_%s_db.zip", nwo.Owner, nwo.Repo, nwo.Owner, nwo.Repo)), map[string]string{"codeql_db/codeql-database.yml": "primaryLanguage: \"cpp\"\n"})
+	}
+	db, err := qldbstore.NewStoreAt(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queue.NewQueueSingle(1, &queue.Visibles{})
+	st := storage.NewStorageSingle(0, &storage.Visibles{})
+	c := server.NewCommanderSingle(&server.Visibles{
+		Queue:       q,
+		ServerStore: st,
+		QLDBStore:   db,
+		Auth:        authn,
+	}, cfg)
+	return c, q
+}
+
+// waitFor waits for cond to hold.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitStatus waits for the job of nwo in session vaid to reach want.
+func waitStatus(t *testing.T, vaid int, nwo common.NameWithOwner, want common.Status) {
+	t.Helper()
+	waitFor(t, fmt.Sprintf("status %v of %v", want, nwo), func() bool {
+		return storage.GetStatus(vaid, nwo) == want
+	})
+}
+
+func TestCompletedJobDownload(t *testing.T) {
+	c, q := newTestCommander(t, mcc.Commander{}, nil)
+
+	const vaid = 990101
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "lib"}
+	storage.SetSession(common.Session{ID: vaid, Owner: "octo", ControllerRepo: "ctl", CreatedAt: time.Now()})
+	storage.AddJob(vaid, common.AnalyzeJob{QueryPackId: vaid, NWO: nwo})
+	storage.SetStatus(vaid, nwo, common.StatusQueued)
+
+	// The agent sends the archive with its result
+	q.Results() <- common.AnalyzeResult{
+		Status:        common.StatusSuccess,
+		QueryPackId:   vaid,
+		NWO:           nwo,
+		ResultCount:   1,
+		ResultArchive: zipBytes(t, map[string]string{"results.sarif": sampleSarif}),
+	}
+	waitStatus(t, vaid, nwo, common.StatusSuccess)
+
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/repos/octo/ctl/code-scanning/codeql/variant-analyses/990101/repos/octo/lib", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("artifact request: %d %s", rec.Code, rec.Body.String())
+	}
+	var dlr common.DownloadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &dlr); err != nil {
+		t.Fatal(err)
+	}
+	au, err := url.Parse(dlr.ArtifactURL)
+	if err != nil || au.Path == "" {
+		t.Fatalf("artifact_url = %q", dlr.ArtifactURL)
+	}
+
+	rec = httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, au.Path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("download: %d %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("download is not a zip archive: %v", err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "results.sarif" {
+		t.Fatalf("download entries = %v", zr.File)
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, _ := io.ReadAll(rc); string(got) != sampleSarif {
+		t.Errorf("results.sarif = %s", got)
+	}
+}
+
+func TestResultWithoutArchiveFails(t *testing.T) {
+	_, q := newTestCommander(t, mcc.Commander{}, nil)
+
+	const vaid = 990102
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "lib"}
+	storage.SetSession(common.Session{ID: vaid, CreatedAt: time.Now()})
+	storage.AddJob(vaid, common.AnalyzeJob{QueryPackId: vaid, NWO: nwo})
+	storage.SetStatus(vaid, nwo, common.StatusQueued)
+
+	q.Results() <- common.AnalyzeResult{Status: common.StatusSuccess, QueryPackId: vaid, NWO: nwo}
+	waitStatus(t, vaid, nwo, common.StatusError)
+}
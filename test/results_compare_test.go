@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/results"
+)
+
+func sarifWithFingerprints(hashes ...string) string {
+	res := ""
+	for i, h := range hashes {
+		if i > 0 {
+			res += ","
+		}
+		res += fmt.Sprintf(`{"ruleId":"py/sql-injection","message":{"text":"m"},`+
+			`"locations":[{"physicalLocation":{"artifactLocation":{"uri":"a.py"},"region":{"startLine":%d}}}],`+
+			`"partialFingerprints":{"primaryLocationLineHash":"%s"}}`, i+1, h)
+	}
+	return `{"version":"2.1.0","runs":[{"tool":{"driver":{"name":"CodeQL"}},"results":[` + res + `]}]}`
+}
+
+func TestCompareSessions(t *testing.T) {
+	dir := t.TempDir()
+	nwo := common.NameWithOwner{Owner: "psycopg", Repo: "psycopg2"}
+	bqrsNWO := common.NameWithOwner{Owner: "google", Repo: "flatbuffers"}
+
+	zip := func(name string, entries map[string]string) string {
+		p := filepath.Join(dir, name)
+		writeZip(t, p, entries)
+		return p
+	}
+
+	base := []results.RepoArchive{
+		{NWO: nwo, Path: zip("b1.zip", map[string]string{"results.sarif": sarifWithFingerprints("h1", "h2", "h2")})},
+		{NWO: bqrsNWO, Path: zip("b2.zip", map[string]string{"results.bqrs": "", "results.csv": "\"x\"\n1\n2\n"})},
+		{NWO: common.NameWithOwner{Owner: "only", Repo: "base"}, Path: zip("b3.zip", map[string]string{})},
+	}
+	// Line numbers shift in head, but fingerprints still match
+	head := []results.RepoArchive{
+		{NWO: nwo, Path: zip("h1.zip", map[string]string{"results.sarif": sarifWithFingerprints("h0", "h2", "h3", "h1")})},
+		{NWO: bqrsNWO, Path: zip("h2.zip", map[string]string{"results.bqrs": "", "results.csv": "\"x\"\n2\n3\n"})},
+	}
+
+	cmp := results.Compare(1, base, 2, head, true)
+
+	if cmp.Added != 3 || cmp.Removed != 2 || cmp.Unchanged != 3 {
+		t.Errorf("totals added=%d removed=%d unchanged=%d, want 3 2 3",
+			cmp.Added, cmp.Removed, cmp.Unchanged)
+	}
+	if len(cmp.Repositories) != 3 {
+		t.Fatalf("got %d repositories, want 3", len(cmp.Repositories))
+	}
+
+	byRepo := map[string]results.RepoComparison{}
+	for _, rc := range cmp.Repositories {
+		byRepo[rc.Repository] = rc
+	}
+	if rc := byRepo["only/base"]; rc.Status != results.CompareStatusMissingInHead {
+		t.Errorf("only/base status = %q", rc.Status)
+	}
+	if rc := byRepo["psycopg/psycopg2"]; rc.AddedCount != 2 || rc.RemovedCount != 1 || len(rc.Added) != 2 {
+		t.Errorf("psycopg/psycopg2 = %+v", rc)
+	}
+	rc := byRepo["google/flatbuffers"]
+	if rc.AddedCount != 1 || rc.RemovedCount != 1 || rc.UnchangedCount != 1 {
+		t.Errorf("google/flatbuffers = %+v", rc)
+	}
+	if len(rc.Added) != 1 || rc.Added[0].Tuple[0] != "3" {
+		t.Errorf("google/flatbuffers added = %+v", rc.Added)
+	}
+}
+
+func TestCompareUnreadableArchive(t *testing.T) {
+	dir := t.TempDir()
+	nwo := common.NameWithOwner{Owner: "psycopg", Repo: "psycopg2"}
+	good := filepath.Join(dir, "good.zip")
+	writeZip(t, good, map[string]string{"results.sarif": sarifWithFingerprints("h1")})
+
+	cmp := results.Compare(1, []results.RepoArchive{{NWO: nwo, Path: good}},
+		2, []results.RepoArchive{{NWO: nwo, Path: filepath.Join(dir, "gone.zip")}}, false)
+
+	if len(cmp.Repositories) != 1 {
+		t.Fatalf("got %d repositories, want the failed one reported", len(cmp.Repositories))
+	}
+	rc := cmp.Repositories[0]
+	if rc.Status != results.CompareStatusError || rc.Error == "" {
+		t.Errorf("psycopg/psycopg2 = %+v, want error status", rc)
+	}
+	if cmp.Added != 0 || cmp.Removed != 0 || cmp.Unchanged != 0 {
+		t.Errorf("totals added=%d removed=%d unchanged=%d, want 0", cmp.Added, cmp.Removed, cmp.Unchanged)
+	}
+}
+
+func TestLoadFindingsMissingArchive(t *testing.T) {
+	ar := results.RepoArchive{
+		NWO:  common.NameWithOwner{Owner: "octo", Repo: "lib"},
+		Path: filepath.Join(t.TempDir(), "gone.zip"),
+	}
+	if _, err := results.LoadFindings(ar); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadFindings error = %v, want fs.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"mrvacommander/pkg/codeql"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/results"
+)
+
+func TestDecodedFileName(t *testing.T) {
+	cases := []struct {
+		bqrs, set, format, want string
+	}{
+		{"results.bqrs", codeql.SelectResultSetName, "csv", "results.csv"},
+		{"codeql/cpp/Foo.bqrs", codeql.SelectResultSetName, "json", "codeql/cpp/Foo.json"},
+		{"codeql/cpp/Foo.bqrs", "edges", "csv", "codeql/cpp/Foo.edges.csv"},
+		{"codeql/cpp/Foo.bqrs", "#nodes", "json", "codeql/cpp/Foo.nodes.json"},
+	}
+	for _, c := range cases {
+		if got := codeql.DecodedFileName(c.bqrs, c.set, c.format); got != c.want {
+			t.Errorf("DecodedFileName(%q, %q, %q) = %q, want %q", c.bqrs, c.set, c.format, got, c.want)
+		}
+	}
+}
+
+func TestResultsArchiveIncludesDecodedFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"results.sarif":        sampleSarif,
+		"bqrs/cpp/Foo.bqrs":    "bqrs",
+		"decoded/cpp/Foo.csv":  "\"col0\"\n\"a\"\n",
+		"decoded/cpp/Foo.json": `{"#select":{"tuples":[["a"]]}}`,
+	}
+	for name, body := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf, err := codeql.GenerateResultsZipArchive(&codeql.RunQueryResult{
+		SarifFilePath: filepath.Join(dir, "results.sarif"),
+		BqrsFilePaths: codeql.BqrsFilePaths{
+			BasePath: filepath.Join(dir, "bqrs"), RelativeFilePaths: []string{"cpp/Foo.bqrs"},
+		},
+		DecodedFilePaths: codeql.BqrsFilePaths{
+			BasePath: filepath.Join(dir, "decoded"), RelativeFilePaths: []string{"cpp/Foo.csv", "cpp/Foo.json"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("GenerateResultsZipArchive: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := []string{"cpp/Foo.bqrs", "cpp/Foo.csv", "cpp/Foo.json", "results.sarif"}
+	if len(names) != len(want) {
+		t.Fatalf("archive entries = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("archive entries = %v, want %v", names, want)
+			break
+		}
+	}
+}
+
+func TestCombineCSV(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.zip")
+	b := filepath.Join(dir, "b.zip")
+	writeZip(t, a, map[string]string{
+		"cpp/Foo.bqrs": "bqrs",
+		"cpp/Foo.csv":  "\"call\",\"file\"\n\"f\",\"a.c\"\n\"g\",\"b.c\"\n",
+	})
+	writeZip(t, b, map[string]string{
+		"cpp/Foo.bqrs": "bqrs",
+		"cpp/Foo.csv":  "\"call\",\"file\"\n\"h\",\"c.c\"\n",
+		// Undecoded result sets are skipped
+		"cpp/Bar.bqrs": "bqrs",
+	})
+
+	var out bytes.Buffer
+	err := results.CombineCSV(&out, []results.RepoArchive{
+		{NWO: common.NameWithOwner{Owner: "octo", Repo: "a"}, Path: a},
+		// A missing archive does not abort the download
+		{NWO: common.NameWithOwner{Owner: "octo", Repo: "gone"}, Path: filepath.Join(dir, "gone.zip")},
+		{NWO: common.NameWithOwner{Owner: "octo", Repo: "b"}, Path: b},
+	}, "")
+	if err != nil {
+		t.Fatalf("CombineCSV: %v", err)
+	}
+	want := "repository,query,call,file\n" +
+		"octo/a,cpp/Foo.bqrs,f,a.c\n" +
+		"octo/a,cpp/Foo.bqrs,g,b.c\n" +
+		"octo/b,cpp/Foo.bqrs,h,c.c\n"
+	if out.String() != want {
+		t.Errorf("CombineCSV =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestCombineCSVMixedColumns(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.zip")
+	writeZip(t, a, map[string]string{
+		"cpp/Bar.bqrs": "bqrs",
+		"cpp/Bar.csv":  "\"function\"\n\"main\"\n",
+		"cpp/Foo.bqrs": "bqrs",
+		"cpp/Foo.csv":  "\"call\",\"file\"\n\"f\",\"a.c\"\n",
+	})
+	archives := []results.RepoArchive{{NWO: common.NameWithOwner{Owner: "octo", Repo: "a"}, Path: a}}
+
+	var out bytes.Buffer
+	err := results.CombineCSV(&out, archives, "")
+	if !errors.Is(err, results.ErrMixedColumns) {
+		t.Fatalf("CombineCSV error = %v, want ErrMixedColumns", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("CombineCSV wrote %q before failing", out.String())
+	}
+
+	// Selecting one query gives a consistent table
+	out.Reset()
+	if err := results.CombineCSV(&out, archives, "cpp/Bar.bqrs"); err != nil {
+		t.Fatalf("CombineCSV for one query: %v", err)
+	}
+	if want := "repository,query,function\nocto/a,cpp/Bar.bqrs,main\n"; out.String() != want {
+		t.Errorf("CombineCSV =\n%s\nwant\n%s", out.String(), want)
+	}
+}
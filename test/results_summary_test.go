@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/results"
+)
+
+func TestSummarize(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.zip")
+	writeZip(t, good, map[string]string{"results.sarif": sarifWithFingerprints("h1", "h2")})
+	bqrs := filepath.Join(dir, "bqrs.zip")
+	writeZip(t, bqrs, map[string]string{"results.bqrs": "", "results.csv": "\"x\"\n1\n"})
+	corrupt := filepath.Join(dir, "corrupt.zip")
+	if err := os.WriteFile(corrupt, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := results.Summarize(7, []results.RepoArchive{
+		{NWO: common.NameWithOwner{Owner: "psycopg", Repo: "psycopg2"}, Path: good},
+		{NWO: common.NameWithOwner{Owner: "google", Repo: "flatbuffers"}, Path: bqrs},
+		{NWO: common.NameWithOwner{Owner: "broken", Repo: "zip"}, Path: corrupt},
+		{NWO: common.NameWithOwner{Owner: "missing", Repo: "zip"}, Path: filepath.Join(dir, "gone.zip")},
+	}, results.SummaryOptions{TopRepositories: 1, SamplesPerRule: 3})
+
+	if sum.RepositoryCount != 2 || sum.TotalCount != 3 {
+		t.Errorf("repository_count=%d total_count=%d, want 2 3", sum.RepositoryCount, sum.TotalCount)
+	}
+	if sum.Severities["warning"] != 2 || sum.Severities["none"] != 1 {
+		t.Errorf("severities = %v", sum.Severities)
+	}
+	if len(sum.Rules) != 2 || sum.Rules[0].RuleID != "py/sql-injection" || sum.Rules[0].Count != 2 {
+		t.Errorf("rules = %+v", sum.Rules)
+	}
+	if len(sum.TopRepositories) != 1 || sum.TopRepositories[0].Repository != "psycopg/psycopg2" {
+		t.Errorf("top_repositories = %+v", sum.TopRepositories)
+	}
+
+	failed := map[string]bool{}
+	for _, fr := range sum.FailedRepositories {
+		failed[fr.Repository] = true
+		if fr.Error == "" {
+			t.Errorf("no error for %s", fr.Repository)
+		}
+	}
+	if len(failed) != 2 || !failed["broken/zip"] || !failed["missing/zip"] {
+		t.Errorf("failed_repositories = %+v", sum.FailedRepositories)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+)
+
+func TestDeleteSession(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	const vaid = 990001
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "lib"}
+
+	qp := filepath.Join(dir, "qp.tgz")
+	if err := os.WriteFile(qp, []byte("pack"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zpath, err := storage.ResultArchivePath(nwo, vaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(zpath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(zpath, []byte("results"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage.SetSession(common.Session{ID: vaid, QueryPack: qp, CreatedAt: time.Now()})
+	storage.AddJob(vaid, common.AnalyzeJob{QueryPackId: vaid, NWO: nwo})
+	storage.SetStatus(vaid, nwo, common.StatusSuccess)
+	key := common.CacheKey{QueryPackHash: "q", DatabaseChecksum: "d", CLIVersion: "v"}
+	storage.SetCachedResult(key, storage.CachedResult{ArchivePath: zpath})
+
+	if got := storage.SessionSize(vaid); got != int64(len("pack")+len("results")) {
+		t.Errorf("SessionSize = %d", got)
+	}
+	if err := storage.DeleteSession(vaid); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+
+	for _, f := range []string{qp, zpath} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s still exists", f)
+		}
+	}
+	if _, ok := storage.GetSession(vaid); ok {
+		t.Error("session still stored")
+	}
+	if storage.GetJobList(vaid) != nil {
+		t.Error("jobs still stored")
+	}
+	if _, ok := storage.GetCachedResult(key); ok {
+		t.Error("cached result still stored")
+	}
+}
+
+// storedFile writes a file below dir with the given modification time.
+func storedFile(t *testing.T, dir, name, content string, mtime time.Time) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func TestSweepLeftoversByAge(t *testing.T) {
+	dir := inTempDir(t)
+	packs := filepath.Join(dir, "var", "codeql", "querypacks")
+	results := filepath.Join(dir, "var", "codeql", "localrun", "results")
+	now := time.Now()
+	oldPack := storedFile(t, packs, "qp-1.tgz", "pack", now.Add(-2*time.Hour))
+	oldResult := storedFile(t, results, "results-octo-lib-1.zip", "results", now.Add(-2*time.Hour))
+	recent := storedFile(t, packs, "qp-2.tgz", "pack", now.Add(-time.Minute))
+
+	startCommander(t, dir, mcc.Commander{Retention: mcc.Retention{MaxAge: time.Hour}}, nil)
+
+	waitFor(t, "leftovers deleted", func() bool { return !exists(oldPack) && !exists(oldResult) })
+	if !exists(recent) {
+		t.Error("leftover younger than MaxAge deleted")
+	}
+}
+
+func TestSweepBySize(t *testing.T) {
+	dir := inTempDir(t)
+	packs := filepath.Join(dir, "var", "codeql", "querypacks")
+	now := time.Now()
+	leftover := storedFile(t, packs, "qp-1.tgz", "leftover-pack", now.Add(-time.Minute))
+
+	// A completed session
+	done := common.NameWithOwner{Owner: "octo", Repo: "done"}
+	donePack := storedFile(t, packs, "qp-990311.tgz", "pack", now)
+	zpath, err := storage.ResultArchivePath(done, 990311)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedFile(t, filepath.Dir(zpath), filepath.Base(zpath), "results", now)
+	storage.SetSession(common.Session{ID: 990311, QueryPack: donePack, CreatedAt: now.Add(-time.Minute)})
+	storage.AddJob(990311, common.AnalyzeJob{QueryPackId: 990311, NWO: done})
+	storage.SetStatus(990311, done, common.StatusSuccess)
+
+	// A session whose analyses are not queued yet
+	newPack := storedFile(t, packs, "qp-990312.tgz", "pack", now)
+	storage.SetSession(common.Session{ID: 990312, QueryPack: newPack, CreatedAt: now})
+
+	startCommander(t, dir, mcc.Commander{Retention: mcc.Retention{MaxTotalBytes: 10}}, nil)
+
+	waitFor(t, "completed session deleted", func() bool {
+		_, ok := storage.GetSession(990311)
+		return !ok
+	})
+	if exists(leftover) || exists(donePack) || exists(zpath) {
+		t.Error("files over the budget kept")
+	}
+	if _, ok := storage.GetSession(990312); !ok || !exists(newPack) {
+		t.Error("session without jobs deleted")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/results"
+)
+
+// writeZip writes a zip archive with the given entries to path.
+func writeZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, zipBytes(t, entries), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// zipBytes returns a zip archive with the given entries.
+func zipBytes(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const sampleSarif = `{"version":"2.1.0","runs":[{"tool":{"driver":{"name":"CodeQL"}},` +
+	`"versionControlProvenance":[{"repositoryUri":"/x","revisionId":"abc123"}],` +
+	`"results":[{"ruleId":"cpp/foo","message":{"text":"m"}}]}]}`
+
+func TestMergeSarif(t *testing.T) {
+	t.Setenv("GITHUB_SERVER_URL", "https://github.example")
+	dir := t.TempDir()
+
+	a := filepath.Join(dir, "a.zip")
+	b := filepath.Join(dir, "b.zip")
+	c := filepath.Join(dir, "c.zip")
+	writeZip(t, a, map[string]string{"results.sarif": sampleSarif})
+	writeZip(t, b, map[string]string{"results.sarif": sampleSarif})
+	writeZip(t, c, map[string]string{"results.bqrs": "not sarif"})
+
+	var out bytes.Buffer
+	err := results.MergeSarif(&out, []results.RepoArchive{
+		{NWO: common.NameWithOwner{Owner: "google", Repo: "flatbuffers"}, Path: a},
+		{NWO: common.NameWithOwner{Owner: "psycopg", Repo: "psycopg2"}, Path: b},
+		{NWO: common.NameWithOwner{Owner: "bqrs", Repo: "only"}, Path: c},
+	})
+	if err != nil {
+		t.Fatalf("MergeSarif: %v", err)
+	}
+
+	var merged struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool                     map[string]interface{} `json:"tool"`
+			Results                  []interface{}          `json:"results"`
+			VersionControlProvenance []struct {
+				RepositoryURI string `json:"repositoryUri"`
+				RevisionID    string `json:"revisionId"`
+			} `json:"versionControlProvenance"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &merged); err != nil {
+		t.Fatalf("merged output is not valid JSON: %v\n%s", err, out.String())
+	}
+	if merged.Version != "2.1.0" {
+		t.Errorf("version = %q", merged.Version)
+	}
+	if len(merged.Runs) != 2 {
+		t.Fatalf("got %d runs, want 2", len(merged.Runs))
+	}
+	want := []string{
+		"https://github.example/google/flatbuffers",
+		"https://github.example/psycopg/psycopg2",
+	}
+	for i, run := range merged.Runs {
+		if run.Tool == nil || len(run.Results) != 1 {
+			t.Errorf("run %d lost its tool or results", i)
+		}
+		if len(run.VersionControlProvenance) != 1 {
+			t.Fatalf("run %d has %d provenance entries", i, len(run.VersionControlProvenance))
+		}
+		vcp := run.VersionControlProvenance[0]
+		if vcp.RepositoryURI != want[i] || vcp.RevisionID != "abc123" {
+			t.Errorf("run %d provenance = %+v", i, vcp)
+		}
+	}
+}
+
+func TestMergeSarifMissingArchive(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.zip")
+	writeZip(t, a, map[string]string{"results.sarif": sampleSarif})
+
+	var out bytes.Buffer
+	err := results.MergeSarif(&out, []results.RepoArchive{
+		{NWO: common.NameWithOwner{Owner: "google", Repo: "flatbuffers"}, Path: a},
+		{NWO: common.NameWithOwner{Owner: "lost", Repo: "archive"}, Path: filepath.Join(dir, "lost.zip")},
+	})
+	if err == nil {
+		t.Fatal("missing archive skipped")
+	}
+	if !strings.Contains(err.Error(), "lost/archive") {
+		t.Errorf("error does not name the repository: %v", err)
+	}
+}
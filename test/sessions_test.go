@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/server"
+	"mrvacommander/pkg/storage"
+)
+
+// seedSession stores a session of login with a repository for each of
+// statuses.
+func seedSession(id int, login, controller, language, hash, created string, statuses ...common.Status) {
+	owner, repo, _ := strings.Cut(controller, "/")
+	at, _ := time.Parse(time.RFC3339, created)
+	sn := common.Session{ID: id, Owner: owner, ControllerRepo: repo, Actor: common.Actor{Login: login},
+		Language: language, QueryPackHash: hash, CreatedAt: at}
+	for i, st := range statuses {
+		nwo := common.NameWithOwner{Owner: "octo", Repo: "lib" + string(rune('a'+i))}
+		sn.Repositories = append(sn.Repositories, nwo)
+		storage.AddJob(id, common.AnalyzeJob{QueryPackId: id, NWO: nwo})
+		storage.SetStatus(id, nwo, st)
+	}
+	storage.SetSession(sn)
+}
+
+// listSessions lists sessions as login and returns the ids listed.
+func listSessions(t *testing.T, c *server.CommanderSingle, login, query string) ([]int, server.SessionList, http.Header) {
+	t.Helper()
+	rec := request(t, c, login, http.MethodGet, "/variant-analyses?"+query, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: %d %s", query, rec.Code, rec.Body.String())
+	}
+	var list server.SessionList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, item := range list.VariantAnalyses {
+		ids = append(ids, item.ID)
+	}
+	return ids, list, rec.Header()
+}
+
+// Sessions of the other tests are created now, after this window.
+const sessionWindow = "created_after=2024-01-01&created_before=2024-12-31"
+
+func seedSessions(t *testing.T) *server.CommanderSingle {
+	t.Helper()
+	c, _ := newTestCommander(t, mcc.Commander{}, adminTokens{})
+	seedSession(994301, "alice", "octo/ctl", "cpp", "hash-a", "2024-01-10T12:00:00Z", common.StatusSuccess)
+	seedSession(994302, "alice", "octo/ctl", "python", "hash-b", "2024-02-10T12:00:00Z",
+		common.StatusSuccess, common.StatusFailed)
+	seedSession(994303, "alice", "acme/other", "cpp", "hash-a", "2024-03-10T12:00:00Z",
+		common.StatusSuccess, common.StatusQueued)
+	seedSession(994304, "bob", "octo/ctl", "cpp", "hash-a", "2024-03-11T12:00:00Z", common.StatusSuccess)
+	return c
+}
+
+func TestListSessionFilters(t *testing.T) {
+	c := seedSessions(t)
+
+	for _, tc := range []struct {
+		login string
+		query string
+		want  []int
+	}{
+		{"root", "", []int{994304, 994303, 994302, 994301}},
+		{"root", "actor=alice", []int{994303, 994302, 994301}},
+		{"root", "actor=ALICE", []int{994303, 994302, 994301}},
+		{"root", "controller_repo=acme/other", []int{994303}},
+		{"root", "controller_repo=OCTO/CTL", []int{994304, 994302, 994301}},
+		{"root", "language=python", []int{994302}},
+		{"root", "status=succeeded", []int{994304, 994301}},
+		{"root", "status=failed", []int{994302}},
+		{"root", "status=in_progress", []int{994303}},
+		{"root", "query_pack_hash=hash-b", []int{994302}},
+		{"root", "actor=alice&language=cpp&status=succeeded", []int{994301}},
+		{"root", "created_after=2024-03-01", []int{994304, 994303}},
+		{"root", "created_after=2024-03-10T12:00:00Z", []int{994304, 994303}},
+		// A date as upper bound includes the whole day
+		{"root", "created_before=2024-02-10", []int{994302, 994301}},
+		{"root", "created_before=2024-02-10T00:00:00Z", []int{994301}},
+		{"root", "language=java", []int{}},
+
+		// Non-admins only see their own sessions
+		{"bob", "", []int{994304}},
+		{"bob", "actor=alice", []int{}},
+		{"alice", "status=succeeded", []int{994301}},
+	} {
+		// The filter given by the case comes first, so it overrides the window
+		query := tc.query
+		if query != "" {
+			query += "&"
+		}
+		ids, list, _ := listSessions(t, c, tc.login, query+sessionWindow)
+		if !reflect.DeepEqual(ids, tc.want) || list.TotalCount != len(tc.want) {
+			t.Errorf("%s as %s: %v (total %d), want %v", tc.query, tc.login, ids, list.TotalCount, tc.want)
+		}
+	}
+
+	ids, list, _ := listSessions(t, c, "root", "actor=alice&"+sessionWindow)
+	if len(ids) > 0 {
+		item := list.VariantAnalyses[0]
+		if item.ControllerRepo != "acme/other" || item.QueryLanguage != "cpp" || item.Status != "in_progress" ||
+			item.RepositoryCount != 2 || item.Actor.Login != "alice" ||
+			item.URL != "http://localhost:8080/repos/acme/other/code-scanning/codeql/variant-analyses/994303" {
+			t.Errorf("listed session = %+v", item)
+		}
+	}
+
+	for _, query := range []string{"status=done", "created_after=yesterday", "created_before=2024-13-01",
+		"page=0", "per_page=0", "per_page=many"} {
+		rec := request(t, c, "root", http.MethodGet, "/variant-analyses?"+query, "")
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: %d, want 422", query, rec.Code)
+		}
+	}
+}
+
+var linkPattern = regexp.MustCompile(`<([^>]*)>; rel="([a-z]+)"`)
+
+// pageLinks returns the page number of each relation in a Link header,
+// checking that the links keep the query.
+func pageLinks(t *testing.T, h http.Header) map[string]string {
+	t.Helper()
+	pages := map[string]string{}
+	for _, m := range linkPattern.FindAllStringSubmatch(h.Get("Link"), -1) {
+		u, err := url.Parse(m[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Scheme+"://"+u.Host+u.Path != "http://localhost:8080/variant-analyses" {
+			t.Errorf("%s link %s", m[2], m[1])
+		}
+		if q := u.Query(); q.Get("per_page") != "1" || q.Get("created_after") != "2024-01-01" {
+			t.Errorf("%s link %s lost the query", m[2], m[1])
+		}
+		pages[m[2]] = u.Query().Get("page")
+	}
+	return pages
+}
+
+func TestListSessionPages(t *testing.T) {
+	c := seedSessions(t)
+
+	for _, tc := range []struct {
+		page  string
+		want  []int
+		links map[string]string
+	}{
+		{"1", []int{994304}, map[string]string{"next": "2", "last": "4"}},
+		{"2", []int{994303}, map[string]string{"next": "3", "last": "4", "first": "1", "prev": "1"}},
+		{"4", []int{994301}, map[string]string{"first": "1", "prev": "3"}},
+		// Past the end, prev leads back to the last page
+		{"9", []int{}, map[string]string{"first": "1", "prev": "4"}},
+	} {
+		ids, list, h := listSessions(t, c, "root", "per_page=1&page="+tc.page+"&"+sessionWindow)
+		if !reflect.DeepEqual(ids, tc.want) || list.TotalCount != 4 {
+			t.Errorf("page %s: %v (total %d), want %v", tc.page, ids, list.TotalCount, tc.want)
+		}
+		if links := pageLinks(t, h); !reflect.DeepEqual(links, tc.links) {
+			t.Errorf("page %s links: %v, want %v", tc.page, links, tc.links)
+		}
+	}
+
+	// A single page has no links
+	ids, _, h := listSessions(t, c, "root", sessionWindow)
+	if len(ids) != 4 || h.Get("Link") != "" {
+		t.Errorf("single page: %v, Link %q", ids, h.Get("Link"))
+	}
+	// per_page is capped rather than rejected
+	if ids, _, _ := listSessions(t, c, "root", "per_page=1000&"+sessionWindow); len(ids) != 4 {
+		t.Errorf("large per_page: %v", ids)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mrvacommander/config/mcc"
+	"mrvacommander/pkg/common"
+	"mrvacommander/pkg/storage"
+	"mrvacommander/pkg/webhook"
+	"mrvacommander/utils"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	var calls int32
+	got := make(chan *http.Request, 1)
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt to exercise the retry
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		got <- r
+	}))
+	defer srv.Close()
+
+	d := webhook.NewDispatcher([]webhook.Subscription{
+		{URL: srv.URL, Secret: "s3cret", Events: []string{webhook.EventSessionCompleted}},
+	}, 2)
+	d.Notify(webhook.EventRepositoryFailed, map[string]int{"session_id": 1})
+	d.Notify(webhook.EventSessionCompleted, map[string]int{"session_id": 1})
+
+	var r *http.Request
+	select {
+	case r = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	if r.Header.Get(webhook.HeaderEvent) != webhook.EventSessionCompleted {
+		t.Errorf("event header = %q", r.Header.Get(webhook.HeaderEvent))
+	}
+	if sig := r.Header.Get(webhook.HeaderSignature); sig != webhook.Sign("s3cret", body) {
+		t.Errorf("signature = %q", sig)
+	}
+
+	// The log is updated after the response is read
+	time.Sleep(100 * time.Millisecond)
+	dls := d.Deliveries()
+	if len(dls) != 1 {
+		t.Fatalf("deliveries = %+v, want only the subscribed event", dls)
+	}
+	if !dls[0].Delivered || dls[0].Attempts != 2 || dls[0].StatusCode != http.StatusOK {
+		t.Errorf("delivery = %+v", dls[0])
+	}
+}
+
+// expectCompleted waits for exactly one session.completed webhook.
+func expectCompleted(t *testing.T, got chan string) {
+	t.Helper()
+	select {
+	case ev := <-got:
+		if ev != webhook.EventSessionCompleted {
+			t.Errorf("event = %q", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session.completed not sent")
+	}
+	select {
+	case ev := <-got:
+		t.Errorf("unexpected second event %q", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSessionCompletedOnSubmit(t *testing.T) {
+	got := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get(webhook.HeaderEvent)
+	}))
+	defer srv.Close()
+
+	nwo := common.NameWithOwner{Owner: "octo", Repo: "hooked"}
+	c, q := newTestCommander(t, mcc.Commander{Webhooks: []mcc.Webhook{{URL: srv.URL}}}, nil, nwo)
+	registerQuotaController()
+
+	// No repository has a database, so nothing is queued
+	if rec := submit(t, c, "alice", "octo/missing"); rec.Code != http.StatusOK {
+		t.Fatalf("submission: %d %s", rec.Code, rec.Body.String())
+	}
+	expectCompleted(t, got)
+
+	// Every repository is answered from the cache
+	tgz, err := base64.StdEncoding.DecodeString(queryPack(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	qpHash, err := utils.TarGzContentHash(tgz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbSum, err := utils.FileChecksum(filepath.Join("codeql", "dbs", "octo", "hooked", "octo_hooked_db.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "cached.zip")
+	if err := os.WriteFile(archive, zipBytes(t, map[string]string{"results.sarif": sampleSarif}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	storage.SetCLIVersion("webhook-test-2.0.0")
+	storage.SetCachedResult(common.CacheKey{QueryPackHash: qpHash, DatabaseChecksum: dbSum,
+		CLIVersion: "webhook-test-2.0.0"}, storage.CachedResult{
+		Result:      common.AnalyzeResult{Status: common.StatusSuccess, NWO: nwo, ResultCount: 1},
+		ArchivePath: archive,
+		CreatedAt:   time.Now(),
+	})
+	if rec := submit(t, c, "alice", "octo/hooked"); rec.Code != http.StatusOK {
+		t.Fatalf("submission: %d %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case <-q.Jobs():
+		t.Fatal("cached repository queued")
+	default:
+	}
+	expectCompleted(t, got)
+}
@@ -3,11 +3,15 @@ package utils
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -123,3 +127,79 @@ func Untar(r io.Reader, dest string) error {
 
 	return nil
 }
+
+// ReadZipEntry returns the contents of the named entry in a zip file.
+// It returns fs.ErrNotExist when the archive has no such entry.
+func ReadZipEntry(zipFile, name string) ([]byte, error) {
+	r, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	rc, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// TarGzContentHash returns a hex sha256 over the names and contents of the
+// regular files in a tar.gz archive.  Unlike a hash of the archive bytes it
+// does not change when the same files are packed again with new timestamps.
+func TarGzContentHash(tgz []byte) (string, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		return "", err
+	}
+	defer gzr.Close()
+
+	files := map[string]string{}
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return "", err
+		}
+		files[filepath.Clean(header.Name)] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%s\n", name, files[name])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FileChecksum returns the hex sha256 of a file's contents.
+func FileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
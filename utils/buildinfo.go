@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is set at build time with
+//
+//	go build -ldflags "-X mrvacommander/utils.Version=v1.2.3"
+var Version = "dev"
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	CommitAt  string `json:"commit_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// GetBuildInfo reports the version of this binary, including the VCS
+// revision recorded by the go toolchain.
+func GetBuildInfo() BuildInfo {
+	bi := BuildInfo{Version: Version, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return bi
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			bi.Commit = s.Value
+		case "vcs.time":
+			bi.CommitAt = s.Value
+		case "vcs.modified":
+			bi.Modified = s.Value == "true"
+		}
+	}
+	return bi
+}